@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/history"
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// alertInterval is how often sensors are checked for alerts
+	alertInterval = 10 * time.Second
+	// alertCommandTimeout bounds each run of --alert-command
+	alertCommandTimeout = time.Minute
+)
+
+// alertFlags are the sensor alert flags
+type alertFlags struct {
+	config  monitor.AlertConfig
+	command string
+	seed    bool
+}
+
+func addAlertFlags(cmd *cobra.Command) *alertFlags {
+	f := &alertFlags{}
+	cmd.Flags().DurationVar(&f.config.Silent, "alert-silent", 0, "Alert when a sensor hasn't been heard from for this long")
+	cmd.Flags().Int32Var(&f.config.Battery, "alert-battery", 0, "Alert when a sensor's battery is at or below this percentage")
+	cmd.Flags().DurationVar(&f.config.BatteryWithin, "alert-battery-within", 0, "Alert when a sensor's battery is estimated to run out within this long (e.g. 336h)")
+	cmd.Flags().StringVar(&f.command, "alert-command", "", "Run this shell command for each alert raised or cleared, see --help")
+	cmd.Flags().BoolVar(&f.seed, "alert-history", false, "Start with last seen times and battery trends from the history file (see --record)")
+	return f
+}
+
+func (f *alertFlags) enabled() bool {
+	return f.config.Silent > 0 || f.config.Battery > 0 || f.config.BatteryWithin > 0
+}
+
+// seedHistory passes received observations from the history file to add
+func seedHistory(cmd *cobra.Command, filter *monitor.Filter, add func(o monitor.Observation)) error {
+	path, err := cmd.Flags().GetString("history-file")
+	if err != nil {
+		return err
+	}
+	return history.Read(path, history.Query{Filter: filter}, func(o monitor.Observation) error {
+		// Simulated sensors don't have batteries to watch
+		if !o.Sent {
+			add(o)
+		}
+		return nil
+	})
+}
+
+// runAlerts checks the tracked sensors every alertInterval until ctx is done,
+// reporting alerts as they're raised and cleared
+func runAlerts(ctx context.Context, alerter *monitor.Alerter, tracker *monitor.Tracker, report func(a monitor.Alert)) {
+	ticker := time.NewTicker(alertInterval)
+	defer ticker.Stop()
+	for {
+		for _, a := range alerter.Check(tracker.Entries(), time.Now()) {
+			report(a)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logAlert logs an alert as a warning, or info when it's cleared
+func logAlert(a monitor.Alert) {
+	l := log.Warn()
+	if a.Cleared {
+		l = log.Info()
+	}
+	l.Str("alert", string(a.Kind)).Str("mac", a.MAC).Str("name", a.Name).Bool("cleared", a.Cleared).Msg(a.String())
+}
+
+// runAlertCommand runs command through the shell with the alert in the
+// environment
+func runAlertCommand(command string, a monitor.Alert) {
+	state := "raised"
+	if a.Cleared {
+		state = "cleared"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), alertCommandTimeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, "sh", "-c", command)
+	c.Env = append(os.Environ(),
+		"TSTAT_ALERT="+string(a.Kind),
+		"TSTAT_ALERT_STATE="+state,
+		"TSTAT_MAC="+a.MAC,
+		"TSTAT_NAME="+a.Name,
+		"TSTAT_MESSAGE="+a.String(),
+	)
+	out, err := c.CombinedOutput()
+	if err != nil {
+		log.Error().Err(err).Str("output", string(out)).Msg("Error running alert command")
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/calibration"
+	"github.com/spf13/cobra"
+)
+
+func CalibrateCmd() *cobra.Command {
+	var mode string
+	var celsius bool
+	var cmd = &cobra.Command{
+		Use:   "calibrate [flags] <samples.csv>",
+		Short: "Fit a calibration from reference and measured readings",
+		Long: `Fit a calibration from a CSV of reference,measured pairs, one per line, for
+example a trusted thermometer's reading next to the probe's at the same time.
+Use - to read from stdin. The result can be pasted into an input's or sensor's
+"calibration" in a run config.
+
+Modes:
+  offset  add a fixed offset
+  linear  gain and offset from a least squares fit, needs at least two
+          different measured values
+  table   piecewise linear through every sample, repeated measured values are
+          averaged`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var r io.Reader = cmd.InOrStdin()
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("error opening samples: %w", err)
+				}
+				defer f.Close()
+				r = f
+			}
+			samples, err := calibration.ReadSamples(r)
+			if err != nil {
+				return err
+			}
+			c, err := calibration.Fit(samples, mode, celsius)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			data, err := json.MarshalIndent(map[string]interface{}{"calibration": c}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, string(data))
+			beforeRMS, beforeMax := calibration.Residuals(nil, samples)
+			afterRMS, afterMax := calibration.Residuals(c, samples)
+			fmt.Fprintf(w, "%d samples, error before: %.3f RMS %.3f max, after: %.3f RMS %.3f max\n", len(samples), beforeRMS, beforeMax, afterRMS, afterMax)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&mode, "mode", "linear", "Calibration to fit ("+strings.Join(calibration.FitModes, ", ")+")")
+	cmd.Flags().BoolVarP(&celsius, "celsius", "c", false, "Samples are Celsius")
+	return cmd
+}
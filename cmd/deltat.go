@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// deltaTFlags set the normal supply/return split ranges
+type deltaTFlags struct {
+	cooling []float64
+	heating []float64
+	config  monitor.DeltaTConfig
+}
+
+func addDeltaTFlags(cmd *cobra.Command) *deltaTFlags {
+	f := &deltaTFlags{config: monitor.DefaultDeltaTConfig()}
+	cmd.Flags().Float64SliceVar(&f.cooling, "cooling-delta-t", []float64{f.config.CoolingMin, f.config.CoolingMax}, "Normal return minus supply range while cooling, in F")
+	cmd.Flags().Float64SliceVar(&f.heating, "heating-delta-t", []float64{f.config.HeatingMin, f.config.HeatingMax}, "Normal supply minus return range while heating, in F (heat pumps are around 15,30)")
+	cmd.Flags().DurationVar(&f.config.Settle, "delta-t-settle", f.config.Settle, "How long a unit has to run before its split is judged")
+	return f
+}
+
+func (f *deltaTFlags) build() (monitor.DeltaTConfig, error) {
+	c := f.config
+	for _, r := range []struct {
+		name     string
+		values   []float64
+		min, max *float64
+	}{{"cooling-delta-t", f.cooling, &c.CoolingMin, &c.CoolingMax}, {"heating-delta-t", f.heating, &c.HeatingMin, &c.HeatingMax}} {
+		if len(r.values) != 2 || r.values[0] < 0 || r.values[0] > r.values[1] {
+			return c, fmt.Errorf("invalid --%s %v, use min,max", r.name, r.values)
+		}
+		*r.min, *r.max = r.values[0], r.values[1]
+	}
+	return c, nil
+}
+
+// reportDeltaT logs a warning when a unit's split becomes abnormal, and when
+// it's back to normal
+func reportDeltaT(last map[int32]monitor.DeltaTState, s monitor.DeltaTStatus) {
+	prev, ok := last[s.UnitID]
+	last[s.UnitID] = s.State
+	switch {
+	case s.Abnormal() && prev != s.State:
+		log.Warn().Int32("unit", s.UnitID).Str("mode", string(s.Mode)).Float64("deltaT", s.DeltaT()).Msg(s.String())
+	case ok && (prev == monitor.DeltaTLow || prev == monitor.DeltaTHigh) && s.State == monitor.DeltaTNormal,
+		ok && prev == monitor.DeltaTCollapsed && !s.Abnormal():
+		log.Info().Int32("unit", s.UnitID).Float64("deltaT", s.DeltaT()).Msg("Delta-T back to normal")
+	}
+}
+
+// serveMetrics serves the tracked sensors and units at /metrics until ctx is done
+func serveMetrics(ctx context.Context, addr string, tracker *monitor.Tracker, deltaT *monitor.DeltaTAnalyzer) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on [%s]: %w", addr, err)
+	}
+	server := &http.Server{Handler: monitor.Handler(tracker, deltaT)}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		err := server.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Error serving metrics")
+		}
+	}()
+	log.Info().Stringer("address", l.Addr()).Msg("Serving metrics")
+	return nil
+}
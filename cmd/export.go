@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/export"
+)
+
+// openExporter opens an exporter writing format (csv or influx) to dest: - for
+// stdout, an http(s) URL for an InfluxDB write endpoint or a file to append to
+func openExporter(format string, dest string, token string) (export.Exporter, error) {
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		if format != "influx" {
+			return nil, fmt.Errorf("only influx can be written to a URL [%s]", dest)
+		}
+		return export.NewInfluxHTTPExporter(dest, token), nil
+	}
+	if format != "csv" && format != "influx" {
+		return nil, fmt.Errorf("invalid export format [%s]", format)
+	}
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	// Appending to an existing CSV file doesn't repeat the header
+	header := true
+	if dest != "-" {
+		f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening [%s]: %w", dest, err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error opening [%s]: %w", dest, err)
+		}
+		header = info.Size() == 0
+		w = f
+	}
+	switch format {
+	case "csv":
+		return export.NewCSVExporter(w, header), nil
+	default:
+		return export.NewLineExporter(w), nil
+	}
+}
+
+// nopCloser keeps an exporter from closing stdout
+type nopCloser struct {
+	*os.File
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
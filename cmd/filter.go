@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/spf13/cobra"
+)
+
+// filterFlags are the message filter flags shared by commands that receive
+type filterFlags struct {
+	macs       []string
+	names      []string
+	nameRegexp []string
+	types      []string
+	units      []int
+	messages   []string
+	from       []string
+	signatures []string
+}
+
+func addFilterFlags(cmd *cobra.Command) *filterFlags {
+	f := &filterFlags{}
+	cmd.Flags().StringSliceVar(&f.macs, "mac", nil, "Only sensors with these MACs")
+	cmd.Flags().StringSliceVar(&f.names, "name", nil, "Only sensors with names matching these globs (* and ?, case insensitive)")
+	cmd.Flags().StringSliceVar(&f.nameRegexp, "name-regexp", nil, "Only sensors with names matching these regular expressions")
+	cmd.Flags().StringSliceVar(&f.types, "type", nil, "Only these sensor types (outdoor, remote, supply, return)")
+	cmd.Flags().IntSliceVar(&f.units, "unit", nil, "Only these unit IDs")
+	cmd.Flags().StringSliceVar(&f.messages, "message", nil, "Only these message types (pair, data)")
+	cmd.Flags().StringSliceVar(&f.from, "from", nil, "Only messages from these IP addresses or CIDR networks")
+	cmd.Flags().StringSliceVar(&f.signatures, "signature", nil, "Only these signature statuses (valid, pair-key, invalid, no-key, pair, error)")
+	return f
+}
+
+func (f *filterFlags) build() (*monitor.Filter, error) {
+	filter := &monitor.Filter{}
+	for _, mac := range f.macs {
+		filter.AddMAC(mac)
+	}
+	for _, name := range f.names {
+		filter.AddNameGlob(name)
+	}
+	for _, re := range f.nameRegexp {
+		if err := filter.AddNameRegexp(re); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range f.types {
+		t, err := sensor.ParseSensorType(s)
+		if err != nil {
+			return nil, err
+		}
+		filter.Types = append(filter.Types, t)
+	}
+	for _, u := range f.units {
+		filter.UnitIDs = append(filter.UnitIDs, int32(u))
+	}
+	for _, m := range f.messages {
+		if err := filter.AddMessageType(m); err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range f.from {
+		if err := filter.AddNetwork(n); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range f.signatures {
+		status, err := sensor.ParseSignatureStatus(s)
+		if err != nil {
+			return nil, err
+		}
+		filter.Signatures = append(filter.Signatures, status)
+	}
+	return filter, nil
+}
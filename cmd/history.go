@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/history"
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/spf13/cobra"
+)
+
+func HistoryCmd() *cobra.Command {
+	var since string
+	var until string
+	var format string
+	var filters *filterFlags
+	var cmd = &cobra.Command{
+		Use:   "history",
+		Short: "Query recorded readings",
+		Long: `Query readings recorded by "dump --record" and "run --record", for example
+what the thermostat saw last night:
+
+  tstat-sensor-go history --since "2022-05-01 22:00" --until "2022-05-02 07:00" --name living
+
+--since and --until take a date ("2022-05-01"), a local date and time
+("2022-05-01 22:00"), an RFC 3339 time or a duration before now ("12h"). The
+filter flags are the same as dump's.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := time.Now()
+			var q history.Query
+			var err error
+			if since != "" {
+				q.Since, err = parseTime(since, now)
+				if err != nil {
+					return err
+				}
+			}
+			if until != "" {
+				q.Until, err = parseTime(until, now)
+				if err != nil {
+					return err
+				}
+			}
+			q.Filter, err = filters.build()
+			if err != nil {
+				return err
+			}
+			path, err := cmd.Flags().GetString("history-file")
+			if err != nil {
+				return err
+			}
+
+			out := bufio.NewWriter(cmd.OutOrStdout())
+			defer out.Flush()
+			switch format {
+			case "csv":
+				w := csv.NewWriter(out)
+				err = w.Write(monitor.CSVHeader)
+				if err != nil {
+					return err
+				}
+				err = history.Read(path, q, func(o monitor.Observation) error {
+					return w.Write(o.CSVRecord())
+				})
+				w.Flush()
+				if err != nil {
+					return err
+				}
+				return w.Error()
+			case "json":
+				enc := json.NewEncoder(out)
+				return history.Read(path, q, func(o monitor.Observation) error {
+					return enc.Encode(o)
+				})
+			default:
+				return fmt.Errorf("invalid format [%s]", format)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "Only readings at or after this time")
+	cmd.Flags().StringVar(&until, "until", "", "Only readings before this time")
+	cmd.Flags().StringVar(&format, "format", "csv", "Output format (csv, json)")
+	filters = addFilterFlags(cmd)
+	return cmd
+}
+
+// parseTime parses an absolute time or a duration before now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time [%s], use a date, \"YYYY-MM-DD HH:MM\", RFC 3339 or a duration like 12h", s)
+}
+
+// openHistory opens the history file for appending
+func openHistory(cmd *cobra.Command) (*history.Writer, error) {
+	path, err := cmd.Flags().GetString("history-file")
+	if err != nil {
+		return nil, err
+	}
+	return history.Create(path)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func LearnCmd() *cobra.Command {
+	var mac string
+	var file string
+	var timeout time.Duration
+	var force bool
+	var cmd = &cobra.Command{
+		Use:   "learn [flags] [sensorName]",
+		Short: "Clone a real sensor's identity from its pairing message",
+		Long: `Wait for a real sensor to send a pairing message (press its pair button) and
+save its MAC, name, type, unit ID, key and sequence number in the key store. After
+that send and pair use the learned identity, so the simulator can take over the
+sensor's slot without re-pairing the thermostat.
+
+If sensorName or --mac is given, pairing messages from other sensors are ignored.
+Stop the real sensor before sending as it, otherwise the two sequence numbers
+will collide.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var want sensor.MAC
+			if mac != "" {
+				var err error
+				want, err = sensor.ParseMAC(mac)
+				if err != nil {
+					return err
+				}
+			}
+			store, err := loadKeyStore(cmd)
+			if err != nil {
+				return err
+			}
+			t, err := openListenTransport(file)
+			if err != nil {
+				return err
+			}
+			defer t.Close()
+			var ctx context.Context
+			var cancel context.CancelFunc
+			if timeout > 0 {
+				ctx, cancel = context.WithTimeout(cmd.Context(), timeout)
+			} else {
+				ctx, cancel = context.WithCancel(cmd.Context())
+			}
+			defer cancel()
+			go func() {
+				<-ctx.Done()
+				t.Close()
+			}()
+
+			log.Info().Msg("Waiting for a pairing message, press the pair button on the sensor")
+			var learned *sensor.Identity
+			var learnErr error
+			err = sensor.Listen(t, func(msg *sensor.SensorMsg, addr net.Addr, err error) {
+				if err != nil || learned != nil || learnErr != nil {
+					return
+				}
+				data := msg.GetDataWithHash().GetSensorData()
+				if msg.GetType() != sensor.MessageType_PAIR {
+					log.Debug().Str("sensor", data.GetSensorName()).Msg("Ignoring data message")
+					return
+				}
+				id, err := sensor.IdentityFromPair(msg)
+				if err != nil {
+					log.Warn().Err(err).Str("from", addr.String()).Msg("Unusable pairing message")
+					return
+				}
+				if len(args) > 0 && id.Name != args[0] {
+					log.Info().Str("sensor", id.Name).Msg("Ignoring pairing message from other sensor")
+					return
+				}
+				if !want.IsZero() && id.MAC != want {
+					log.Info().Stringer("mac", id.MAC).Msg("Ignoring pairing message from other sensor")
+					return
+				}
+				if existing, ok := store.Get(id.Name); ok && !force && existing.MAC != id.MAC {
+					learnErr = fmt.Errorf("key store already has a different sensor named [%s], use --force to replace it", id.Name)
+				} else {
+					learned = id
+				}
+				t.Close()
+			})
+			if learnErr != nil {
+				return learnErr
+			}
+			if learned == nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("no pairing message received: %w", ctx.Err())
+				}
+				if errors.Is(err, io.EOF) {
+					return errors.New("no pairing message received")
+				}
+				return fmt.Errorf("error reading from socket: %w", err)
+			}
+
+			store.Put(learned)
+			err = store.Save()
+			if err != nil {
+				return err
+			}
+			log.Info().
+				Str("sensor", learned.Name).
+				Stringer("mac", learned.MAC).
+				Stringer("type", learned.Type).
+				Int("unitId", learned.UnitID).
+				Int("seq", learned.Seq).
+				Msg("Learned sensor identity")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&mac, "mac", "m", "", "Only learn the sensor with this MAC")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Read hex encoded packets from a file instead of the network")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up after this long (0 waits forever)")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing sensor with the same name")
+
+	return cmd
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func PairCmd() *cobra.Command {
+	var celsius bool
+	var rotate bool
+	var typeStr string
+	var unitId int
+	var addr string
+	var window time.Duration
+	var interval time.Duration
+	var cmd = &cobra.Command{
+		Use:   "pair [flags] -- <sensorName> <temperature>",
+		Short: "Pair a simulated sensor with a random key",
+		Long: `Pair a simulated sensor. The first time a sensor is paired a random key is
+generated and saved in the key store along with its MAC, type and unit ID. Later
+pairs resend the stored key unless --rotate is set, in which case a new key is
+generated and the thermostat must accept the new pairing.
+
+Put the thermostat in pairing mode first. Use --window to keep sending the pairing
+message while you do.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			temp, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("temperature not float: %w", err)
+			}
+			sensorType, err := sensor.ParseSensorType(typeStr)
+			if err != nil {
+				return err
+			}
+			store, err := loadKeyStore(cmd)
+			if err != nil {
+				return err
+			}
+
+			id, ok := store.Get(args[0])
+			if !ok {
+				id, err = sensor.NewIdentity(args[0], sensorType, unitId)
+				if err != nil {
+					return err
+				}
+				log.Info().Str("sensor", id.Name).Stringer("mac", id.MAC).Msg("Created new sensor identity")
+			} else if rotate {
+				err = id.Rotate()
+				if err != nil {
+					return err
+				}
+				log.Info().Str("sensor", id.Name).Msg("Rotated sensor key")
+			}
+			if cmd.Flags().Changed("type") {
+				id.Type = sensorType
+			}
+			if cmd.Flags().Changed("unitid") {
+				id.UnitID = unitId
+			}
+			store.Put(id)
+			// Save before sending so a key the thermostat may have accepted is never lost
+			err = store.Save()
+			if err != nil {
+				return err
+			}
+
+			sender, err := sensor.NewUDPSender(cmd.Context(), addr)
+			if err != nil {
+				return err
+			}
+			defer sender.Close()
+
+			deadline := time.Now().Add(window)
+			for {
+				msg, _, err := id.Build(sensor.Temperature{Value: temp, Celsius: celsius}, true)
+				if err != nil {
+					return err
+				}
+				err = sender.Send(cmd.Context(), msg)
+				if err != nil {
+					return err
+				}
+				err = store.Save()
+				if err != nil {
+					return err
+				}
+				log.Info().Str("sensor", id.Name).Msg("Sent pairing message")
+				if time.Now().Add(interval).After(deadline) {
+					return nil
+				}
+				select {
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&addr, "address", "a", "255.255.255.255", "Address to send to")
+	cmd.Flags().BoolVarP(&celsius, "celsius", "c", false, "Temp is Celsius")
+	cmd.Flags().BoolVarP(&rotate, "rotate", "r", false, "Generate a new key for an already paired sensor")
+	cmd.Flags().StringVarP(&typeStr, "type", "t", "remote", "Sensor type (outdoor, remote, supply, return)")
+	cmd.Flags().IntVarP(&unitId, "unitid", "u", 1, "Unit ID")
+	cmd.Flags().DurationVarP(&window, "window", "w", 0, "Keep sending the pairing message for this long")
+	cmd.Flags().DurationVarP(&interval, "interval", "i", 5*time.Second, "Delay between pairing messages within the window")
+
+	return cmd
+}
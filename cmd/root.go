@@ -1,16 +1,22 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
+	"io"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/marwatk/tstat-sensor-go/pkg/history"
 	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
-	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func RootCmd() *cobra.Command {
@@ -41,48 +47,16 @@ func RootCmd() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (error,warn,info,debug,trace)")
+	cmd.PersistentFlags().String("keystore", sensor.DefaultKeyStorePath(), "Path to the sensor key store")
+	cmd.PersistentFlags().String("history-file", history.DefaultPath(), "Path to the reading history file")
 	cmd.AddCommand(SendCmd())
+	cmd.AddCommand(PairCmd())
+	cmd.AddCommand(LearnCmd())
+	cmd.AddCommand(RunCmd())
+	cmd.AddCommand(ScheduleCmd())
+	cmd.AddCommand(CalibrateCmd())
 	cmd.AddCommand(DumpCmd())
-	return cmd
-}
-
-func DumpCmd() *cobra.Command {
-	dupes := false
-	last := ""
-	var cmd = &cobra.Command{
-		Use:   "dump",
-		Short: "Listen and output messages as they arrive",
-		Args:  cobra.ExactArgs(0),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			l, err := net.ListenPacket("udp4", ":5001")
-			if err != nil {
-				return fmt.Errorf("error listening on port 5001: %w", err)
-			}
-			defer l.Close()
-
-			buf := make([]byte, 2048)
-			for {
-				size, addr, err := l.ReadFrom(buf)
-				if err != nil {
-					return fmt.Errorf("error reading from socket: %w", err)
-				}
-				msg := sensor.SensorMsg{}
-				err = proto.Unmarshal(buf[:size], &msg)
-				if err != nil {
-					fmt.Printf("error unmarshalling: %v\n", err)
-				}
-				this := msg.String()
-				if dupes || this != last {
-					fmt.Printf("From %s\n", addr)
-					sensor.DumpMessage(&msg)
-					last = this
-					fmt.Println("")
-				}
-			}
-		},
-	}
-	cmd.Flags().BoolVarP(&dupes, "show-duplicates", "d", false, "Show duplicate messages")
-
+	cmd.AddCommand(HistoryCmd())
 	return cmd
 }
 
@@ -90,11 +64,18 @@ func SendCmd() *cobra.Command {
 	var celsius bool
 	var pair bool
 	var mac string
+	var legacyMAC bool
 	var keyStr string
 	var typeStr string
 	var seqNum int
 	var unitId int
 	var addr string
+	var dryRun bool
+	var format string
+	var file string
+	var burst sensor.Burst
+	var rounding string
+	var clamp bool
 	var cmd = &cobra.Command{
 		Use:   "send [flags] -- <sensorName> <temperature>",
 		Short: "Send a reading",
@@ -111,32 +92,172 @@ so that negative temps aren't treated as an errant flag`,
 				keyB = []byte(keyStr)
 			}
 
-			sensorType := sensor.SensorType_REMOTE
-			switch strings.ToLower(typeStr) {
-			case "outdoor":
-				sensorType = sensor.SensorType_OUTDOOR
-			case "remote":
-				sensorType = sensor.SensorType_REMOTE
-			case "supply":
-				sensorType = sensor.SensorType_SUPPLY
-			case "return":
-				sensorType = sensor.SensorType_RETURN
-			default:
-				return fmt.Errorf("invalid sensor type [%s]", typeStr)
+			sensorType, err := sensor.ParseSensorType(typeStr)
+			if err != nil {
+				return err
+			}
+
+			roundingMode, err := sensor.ParseRounding(rounding)
+			if err != nil {
+				return err
+			}
+
+			var macV sensor.MAC
+			if mac != "" {
+				macV, err = sensor.ParseMAC(mac)
+				if err != nil {
+					return err
+				}
+			}
+
+			store, err := loadKeyStore(cmd)
+			if err != nil {
+				return err
+			}
+			id, stored := store.Get(args[0])
+			if !stored {
+				id = &sensor.Identity{
+					Name:   args[0],
+					MAC:    sensor.GenerateMAC(args[0]),
+					Key:    sensor.GenerateKey(args[0]),
+					Type:   sensorType,
+					UnitID: unitId,
+					Seq:    sensor.GenerateSeqNum() - 1,
+				}
+			}
+			// Flags override the stored identity for this send only, the
+			// copy keeps them out of the key store
+			c := *id
+			overridden := false
+			if !macV.IsZero() {
+				c.MAC = macV
+				c.LegacyMAC = false
+				overridden = true
+			}
+			if cmd.Flags().Changed("legacy-mac") {
+				c.LegacyMAC = legacyMAC
+				overridden = true
+			}
+			if keyB != nil {
+				c.Key = keyB
+				overridden = true
+			}
+			if cmd.Flags().Changed("type") {
+				c.Type = sensorType
+				overridden = true
+			}
+			if cmd.Flags().Changed("unitid") {
+				c.UnitID = unitId
+				overridden = true
+			}
+			if seqNum != -1 {
+				c.Seq = seqNum - 1
+				overridden = true
+			}
+			t := sensor.Temperature{Value: temp, Celsius: celsius}
+			encode := sensor.Encode
+			if clamp {
+				encode = sensor.EncodeClamped
+			}
+			code, err := encode(t, roundingMode)
+			if err != nil {
+				return err
+			}
+			msg, data, err := c.BuildCode(code, pair)
+			if err != nil {
+				return err
+			}
+			// Only the stored identity's own sequence number moves on
+			if stored && !overridden && !dryRun {
+				id.Seq = c.Seq
+				err = store.Save()
+				if err != nil {
+					return err
+				}
+			}
+			if dryRun {
+				return printMessage(cmd.OutOrStdout(), msg, data, format)
 			}
 
-			return sensor.SimpleSend(sensor.Temperature{Value: temp, Celsius: celsius}, args[0], pair, mac, keyB, sensorType, seqNum, unitId, addr)
+			var sender *sensor.Sender
+			if file != "" {
+				f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return fmt.Errorf("error opening packet file: %w", err)
+				}
+				sender = sensor.NewSender(sensor.NewFileTransport(file, nil, f))
+			} else {
+				sender, err = sensor.NewUDPSender(cmd.Context(), addr)
+				if err != nil {
+					return err
+				}
+			}
+			defer sender.Close()
+			return sender.SendBurst(cmd.Context(), msg, burst)
 		},
 	}
 
 	cmd.Flags().StringVarP(&addr, "address", "a", "255.255.255.255", "Address to send to")
 	cmd.Flags().BoolVarP(&celsius, "celsius", "c", false, "Temp is Celsius")
 	cmd.Flags().BoolVarP(&pair, "pair", "p", false, "Send as a pairing message")
-	cmd.Flags().StringVarP(&mac, "mac", "m", "", "MAC address of simulated sensor (blank will be generated from sensorName)")
-	cmd.Flags().StringVarP(&keyStr, "key", "k", "", "Signature Key (blank will be generated from sensorName)")
+	cmd.Flags().StringVarP(&mac, "mac", "m", "", "MAC address of simulated sensor (blank will be the paired MAC or generated from sensorName)")
+	cmd.Flags().BoolVar(&legacyMAC, "legacy-mac", false, "Generate the MAC without zero padding like older versions did, for sensors already paired that way")
+	cmd.Flags().StringVarP(&keyStr, "key", "k", "", "Signature Key (blank will be the paired key or generated from sensorName)")
 	cmd.Flags().StringVarP(&typeStr, "type", "t", "remote", "Sensor type (outdoor, remote, supply, return)")
 	cmd.Flags().IntVarP(&seqNum, "seqnum", "s", -1, "Reading sequence number (-1 means generate from time of day)")
 	cmd.Flags().IntVarP(&unitId, "unitid", "u", 1, "Unit ID")
+	cmd.Flags().StringVar(&rounding, "rounding", "nearest", "How to round to the thermostat's 0.9F steps (nearest, floor, ceil)")
+	cmd.Flags().BoolVar(&clamp, "clamp", false, "Send temperatures outside -40F to 140F as the nearest limit instead of refusing them")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the message instead of sending it")
+	cmd.Flags().StringVar(&format, "format", "text", "Dry run output format (text, json, hex)")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Append the hex encoded packet to a file instead of sending it")
+	cmd.Flags().IntVar(&burst.Count, "burst-count", 1, "Number of identical copies to send")
+	cmd.Flags().DurationVar(&burst.Spacing, "burst-spacing", 250*time.Millisecond, "Delay between burst copies")
+	cmd.Flags().DurationVar(&burst.Jitter, "burst-jitter", 0, "Random extra delay added to each burst spacing")
 
 	return cmd
 }
+
+func printMessage(w io.Writer, msg *sensor.SensorMsg, data []byte, format string) error {
+	switch strings.ToLower(format) {
+	case "text":
+		fmt.Fprintln(w, msg.String())
+	case "json":
+		// protojson deliberately randomizes whitespace, re-indent so the output is stable
+		j, err := protojson.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("error marshalling json: %w", err)
+		}
+		var buf bytes.Buffer
+		err = json.Indent(&buf, j, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error indenting json: %w", err)
+		}
+		fmt.Fprintln(w, buf.String())
+	case "hex":
+		fmt.Fprintln(w, hex.EncodeToString(data))
+	default:
+		return fmt.Errorf("invalid format [%s]", format)
+	}
+	return nil
+}
+
+func loadKeyStore(cmd *cobra.Command) (*sensor.KeyStore, error) {
+	path, err := cmd.Flags().GetString("keystore")
+	if err != nil {
+		return nil, err
+	}
+	return sensor.LoadKeyStore(path)
+}
+
+// openListenTransport reads packets from file if set, otherwise the network
+func openListenTransport(file string) (sensor.Transport, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("error opening packet file: %w", err)
+		}
+		return sensor.NewFileTransport(file, f, nil), nil
+	}
+	return sensor.NewListenTransport()
+}
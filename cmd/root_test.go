@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+// execute runs the root command with args
+func execute(t *testing.T, args ...string) error {
+	cmd := RootCmd()
+	cmd.SetArgs(args)
+	cmd.SilenceUsage = true
+	return cmd.Execute()
+}
+
+func TestSendOverridesAreNotSaved(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.json")
+	packets := filepath.Join(dir, "packets.hex")
+	store, err := sensor.LoadKeyStore(path)
+	assert.NoError(t, err)
+	id, err := sensor.NewIdentity("Living", sensor.SensorType_REMOTE, 1)
+	assert.NoError(t, err)
+	store.Put(id)
+	assert.NoError(t, store.Save())
+
+	load := func() sensor.Identity {
+		store, err := sensor.LoadKeyStore(path)
+		assert.NoError(t, err)
+		got, ok := store.Get("Living")
+		assert.True(t, ok)
+		return *got
+	}
+
+	for _, override := range [][]string{
+		{"--key", "not the paired key"},
+		{"--mac", "0a:00:00:00:00:01"},
+		{"--legacy-mac"},
+		{"--type", "outdoor"},
+		{"--unitid", "4"},
+		{"--seqnum", "99"},
+	} {
+		args := append([]string{"--keystore", path, "send", "-f", packets}, override...)
+		assert.NoError(t, execute(t, append(args, "--", "Living", "70")...))
+		got := load()
+		assert.Equal(t, *id, got, "%v doesn't change the stored identity", override)
+	}
+
+	assert.NoError(t, execute(t, "--keystore", path, "send", "-f", packets, "--", "Living", "70"))
+	got := load()
+	assert.Equal(t, id.Seq+1, got.Seq, "Sequence number advances")
+	got.Seq = id.Seq
+	assert.Equal(t, *id, got, "Nothing else changes")
+}
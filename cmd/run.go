@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sim"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func RunCmd() *cobra.Command {
+	var file string
+	var statusAddr string
+	var record bool
+	var cmd = &cobra.Command{
+		Use:   "run [flags] <config.json>",
+		Short: "Run simulated sensors fed from local inputs",
+		Long: `Run the simulated sensors described in a JSON config file until interrupted.
+Each sensor sends the latest reading from one of the config's inputs on its
+interval, signing with its key store identity if it has been paired or learned.
+
+Example config:
+
+  {
+    "inputs": {
+      "probe": {"type": "w1", "path": "/sys/bus/w1/devices/28-0000075565e3/w1_slave", "interval": "30s"}
+    },
+    "sensors": [
+      {"name": "Living", "input": "probe", "interval": "1m"}
+    ]
+  }
+
+Input types:
+  w1     DS18B20 style w1_slave file
+  hwmon  hwmon temp*_input file
+  exec   run "command" (a list of program and arguments) every interval, with
+         an optional "timeout", and parse its output
+  file   read "path" whenever it changes
+  http   fetch "url" every interval and extract the value at the JSON path
+         "query" (e.g. $.sensors[0].temp), with optional "headers"
+
+exec and file outputs can be plain numbers (Fahrenheit unless the input sets
+"celsius": true) or have a unit suffix like 21.5C or 70F.
+
+Every input can set "maxBackoff" to cap how far polling slows down while it's
+failing and "maxAge" after which its last reading is considered stale.
+
+Inputs can also list "filters", applied to each reading in order before it's
+used. Rejected readings are logged and the previous reading is kept:
+
+  "filters": [
+    {"type": "bounds", "min": -10, "max": 50, "celsius": true},
+    {"type": "rate", "maxRate": 2},
+    {"type": "median", "window": 3},
+    {"type": "ema", "alpha": 0.3}
+  ]
+
+  bounds  reject readings outside "min" and "max"
+  rate    reject readings changing more than "maxRate" degrees per minute, a
+          change that lasts more than "maxRejects" readings (default 3) is
+          accepted
+  median  median of the last "window" readings
+  ema     exponential moving average, smaller "alpha" is smoother
+
+Filter values are Fahrenheit unless the filter sets "celsius": true.
+
+Inputs and sensors can set "calibration" to correct a probe that reads off. An
+input's is applied before its filters, a sensor's to the value it sends:
+
+  "calibration": {"offset": -1.5}
+  "calibration": {"gain": 1.02, "offset": -2.7}
+  "calibration": {"points": [{"measured": 61.5, "reference": 60}, {"measured": 71.2, "reference": 70}]}
+
+Values are Fahrenheit unless the calibration sets "celsius": true. Use
+"calibrate" to fit one from reference readings.
+
+The thermostat only sees temperatures in steps of about 0.9F. A sensor can set
+"rounding" (nearest, floor or ceil) and a "deadBand" in degrees: the sent value
+only changes once the reading moves that far past the rounding boundary, so a
+reading hovering on a boundary doesn't flip the sent value every interval.
+
+Values outside -40F to 140F, the range the thermostat accepts, aren't sent
+unless the sensor sets "clamp": true to send the nearest limit instead.
+
+Instead of "input" a sensor can set "expression" to compute its value from
+several inputs, for example:
+
+  "groups": {"bedrooms": ["bed1", "bed2"]},
+  "sensors": [
+    {"name": "Average", "expression": "mean(bedrooms) if hour() >= 22 else living"}
+  ]
+
+Expressions support + - * / %, comparisons, and/or/not, "a if cond else b" and
+the functions mean (avg), median, min, max, count, clamp(x, lo, hi), abs, round,
+hour(), minute(), time() (fractional hours) and weekday() (0 is Sunday). Inputs
+are converted to Fahrenheit, or Celsius if the sensor sets "celsius": true. Group
+members that haven't been read are left out.
+
+Or a sensor can set "schedule" to send a weighted mean of inputs and groups that
+changes with the day and time. The first matching entry is used:
+
+  "schedule": [
+    {"name": "night", "days": ["weekdays"], "from": "22:00", "to": "07:00", "weights": {"bedrooms": 1}},
+    {"name": "day", "weights": {"living": 2, "kitchen": 1}}
+  ]
+
+Use "schedule preview" to check which entry applies at a given time.
+
+When a sensor's inputs go stale (see "maxAge") it keeps sending the last
+readings, unless it sets a "failsafe":
+
+  "failsafe": {"action": "stop"}
+  "failsafe": {"action": "fallback", "value": 68}
+  "failsafe": {"action": "backup", "input": "spare"}
+
+stop stops sending so the thermostat falls back to its own sensor, fallback
+sends a fixed value and backup sends another input. The failsafe can also set
+"maxAge" to engage when the oldest reading used is older than that.
+
+Instead of sending every interval a sensor can send as soon as its value
+changes and otherwise only as a heartbeat, like battery sensors do:
+
+  "interval": "15s",
+  "onChange": {"heartbeat": "10m", "minInterval": "1m"}
+
+The value is checked every interval and whenever an input is read. A changed
+value is sent unless the last send was less than "minInterval" ago, in which
+case it's sent once that has passed. An unchanged value is resent every
+"heartbeat" (default 10m). Combine with "deadBand" to ignore noise.
+
+With --status-addr the state of every sensor and input, including failsafes, is
+served as JSON at /status and as Prometheus metrics at /metrics.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := sim.LoadConfig(args[0])
+			if err != nil {
+				return err
+			}
+			store, err := loadKeyStore(cmd)
+			if err != nil {
+				return err
+			}
+			var t sensor.Transport
+			if file != "" {
+				f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return fmt.Errorf("error opening packet file: %w", err)
+				}
+				t = sensor.NewFileTransport(file, nil, f)
+			}
+			runner, err := sim.NewRunner(config, store, t)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := runner.Close()
+				if err != nil {
+					log.Error().Err(err).Msg("Error saving sequence numbers")
+				}
+			}()
+			if record {
+				w, err := openHistory(cmd)
+				if err != nil {
+					return err
+				}
+				defer w.Close()
+				runner.OnSent = func(s *sim.Sensor, msg *sensor.SensorMsg) {
+					o := monitor.Observe(msg, nil, sensor.SignatureValid, time.Now())
+					o.Sent = true
+					err := w.Append(o)
+					if err != nil {
+						log.Error().Err(err).Msg("Error recording history")
+					}
+				}
+			}
+
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(signals)
+			go func() {
+				select {
+				case sig := <-signals:
+					log.Info().Stringer("signal", sig).Msg("Shutting down")
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			if statusAddr != "" {
+				l, err := net.Listen("tcp", statusAddr)
+				if err != nil {
+					return fmt.Errorf("error listening on [%s]: %w", statusAddr, err)
+				}
+				server := &http.Server{Handler: runner.Handler()}
+				go func() {
+					<-ctx.Done()
+					server.Close()
+				}()
+				go func() {
+					err := server.Serve(l)
+					if err != nil && err != http.ErrServerClosed {
+						log.Error().Err(err).Msg("Error serving status")
+					}
+				}()
+				log.Info().Stringer("address", l.Addr()).Msg("Serving status and metrics")
+			}
+
+			log.Info().Int("sensors", len(config.Sensors)).Int("inputs", len(config.Inputs)).Msg("Running simulated sensors")
+			return runner.Run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Append hex encoded packets to a file instead of sending them")
+	cmd.Flags().BoolVar(&record, "record", false, "Append sent readings to the history file (see history)")
+	cmd.Flags().StringVar(&statusAddr, "status-addr", "", "Serve JSON status at /status and Prometheus metrics at /metrics on this address (e.g. :9100)")
+
+	return cmd
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/schedule"
+	"github.com/marwatk/tstat-sensor-go/pkg/sim"
+	"github.com/spf13/cobra"
+)
+
+func ScheduleCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "schedule",
+		Short: "Inspect sensor schedules",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return errors.New("need subcommand")
+		},
+	}
+	cmd.AddCommand(SchedulePreviewCmd())
+	return cmd
+}
+
+func SchedulePreviewCmd() *cobra.Command {
+	var at string
+	var week bool
+	var step time.Duration
+	var cmd = &cobra.Command{
+		Use:   "preview [flags] <config.json> <sensorName>",
+		Short: "Show which inputs a scheduled sensor uses at a given time",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := sim.LoadConfig(args[0])
+			if err != nil {
+				return err
+			}
+			s, ok := config.Sensor(args[1])
+			if !ok {
+				return fmt.Errorf("no sensor named [%s]", args[1])
+			}
+			if s.Schedule == nil {
+				return fmt.Errorf("sensor [%s] doesn't have a schedule", s.Name)
+			}
+			when := time.Now()
+			if at != "" {
+				when, err = time.ParseInLocation("2006-01-02 15:04", at, time.Local)
+				if err != nil {
+					return fmt.Errorf("invalid time [%s], use \"YYYY-MM-DD HH:MM\"", at)
+				}
+			}
+			w := cmd.OutOrStdout()
+			if week {
+				if step <= 0 {
+					return errors.New("step must be positive")
+				}
+				printWeek(w, s.Schedule, when, step)
+				return nil
+			}
+			printEntry(w, config, s.Schedule, when)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&at, "at", "", "Local time to preview as \"YYYY-MM-DD HH:MM\" (default now)")
+	cmd.Flags().BoolVar(&week, "week", false, "Show which entry is active over the week starting at --at")
+	cmd.Flags().DurationVar(&step, "step", 15*time.Minute, "Resolution of --week")
+
+	return cmd
+}
+
+func printEntry(w io.Writer, config *sim.Config, sched schedule.Schedule, when time.Time) {
+	i := sched.Active(when)
+	fmt.Fprintf(w, "At %s: ", when.Format("Mon 2006-01-02 15:04"))
+	if i < 0 {
+		fmt.Fprintln(w, "no entry active, nothing is sent")
+		return
+	}
+	e := sched[i]
+	fmt.Fprintf(w, "entry %s\n", e.Label(i))
+	total := 0.0
+	for _, w := range e.Weights {
+		total += w
+	}
+	for _, name := range e.Inputs() {
+		members := ""
+		if group, ok := config.Groups[name]; ok {
+			members = fmt.Sprintf(" (mean of %s)", strings.Join(group, ", "))
+		}
+		fmt.Fprintf(w, "  %-20s %5.1f%%%s\n", name, 100*e.Weights[name]/total, members)
+	}
+}
+
+func printWeek(w io.Writer, sched schedule.Schedule, start time.Time, step time.Duration) {
+	label := func(i int) string {
+		if i < 0 {
+			return "(none, nothing is sent)"
+		}
+		return sched[i].Label(i)
+	}
+	end := start.Add(7 * 24 * time.Hour)
+	from, current := start, sched.Active(start)
+	for t := start.Add(step); ; t = t.Add(step) {
+		if !t.Before(end) {
+			t = end
+		}
+		i := sched.Active(t)
+		if i != current || t.Equal(end) {
+			fmt.Fprintf(w, "%s - %s  %s\n", from.Format("Mon 15:04"), t.Format("Mon 15:04"), label(current))
+			from, current = t, i
+		}
+		if t.Equal(end) {
+			return
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/marwatk/tstat-sensor-go/pkg/term"
+)
+
+// tuiView is what the live table is showing
+type tuiView struct {
+	sortColumn int
+	reverse    bool
+	selected   int
+	// detail is the MAC whose history is shown, blank for the table
+	detail string
+	// status is shown below the header, e.g. when the input ends
+	status string
+}
+
+// runTUI shows received messages as a live table, one row per MAC, until q is
+// pressed or ctx is done
+func runTUI(ctx context.Context, t sensor.Transport, ring *sensor.KeyRing, filter *monitor.Filter, observe func(o monitor.Observation), tracker *monitor.Tracker, alerter *monitor.Alerter, deltaT *monitor.DeltaTAnalyzer, in *os.File, out *os.File, celsius bool) error {
+	restore, err := term.MakeRaw(in.Fd())
+	if err != nil {
+		return fmt.Errorf("error setting up terminal (--tui needs an interactive terminal): %w", err)
+	}
+	defer restore()
+	// Alternate screen with the cursor hidden
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	updated := make(chan struct{}, 1)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- monitor.Receive(t, ring, filter, func(o monitor.Observation, msg *sensor.SensorMsg, err error) {
+			if msg == nil {
+				return
+			}
+			observe(o)
+			tracker.Add(o)
+			deltaT.Add(o)
+			select {
+			case updated <- struct{}{}:
+			default:
+			}
+		})
+	}()
+	keys := make(chan string)
+	go readKeys(in, keys)
+
+	view := &tuiView{sortColumn: monitor.ColumnByKey('m')}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		drawTUI(out, tracker, alerter, deltaT, view, celsius)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-updated:
+		case err := <-listenErr:
+			if !errors.Is(err, io.EOF) {
+				return fmt.Errorf("error reading from socket: %w", err)
+			}
+			view.status = "End of input, press Q to quit"
+		case key := <-keys:
+			if !view.handle(key, tracker) {
+				return nil
+			}
+		}
+	}
+}
+
+// handle applies a key press, returning false to quit
+func (v *tuiView) handle(key string, tracker *monitor.Tracker) bool {
+	switch key {
+	case "Q", "q", "\x03":
+		return false
+	}
+	if v.detail != "" {
+		switch key {
+		case "esc", "\x7f", "h", "left", "\r":
+			v.detail = ""
+		}
+		return true
+	}
+	switch key {
+	case "up", "k":
+		if v.selected > 0 {
+			v.selected--
+		}
+	case "down", "j":
+		v.selected++
+	case "\r", "right", "l":
+		entries := v.entries(tracker)
+		if v.selected < len(entries) {
+			v.detail = entries[v.selected].Last.MAC
+		}
+	default:
+		if len(key) == 1 {
+			if c := monitor.ColumnByKey(key[0]); c >= 0 {
+				if c == v.sortColumn {
+					v.reverse = !v.reverse
+				} else {
+					v.sortColumn = c
+					v.reverse = false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (v *tuiView) entries(tracker *monitor.Tracker) []monitor.Entry {
+	entries := tracker.Entries()
+	monitor.Sort(entries, v.sortColumn, v.reverse)
+	return entries
+}
+
+func drawTUI(out *os.File, tracker *monitor.Tracker, alerter *monitor.Alerter, deltaT *monitor.DeltaTAnalyzer, view *tuiView, celsius bool) {
+	_, height, err := term.Size(out.Fd())
+	if err != nil || height < 5 {
+		height = 24
+	}
+	now := time.Now()
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	if view.detail != "" {
+		e, _ := tracker.Entry(view.detail)
+		if len(e.History) > height-5 {
+			e.History = e.History[len(e.History)-(height-5):]
+		}
+		monitor.RenderHistory(&b, e, now, celsius)
+		b.WriteString("\r\nesc/h back  q quit\r\n")
+		out.Write(b.Bytes())
+		return
+	}
+	entries := view.entries(tracker)
+	if view.selected >= len(entries) {
+		view.selected = len(entries) - 1
+	}
+	if view.selected < 0 {
+		view.selected = 0
+	}
+	var sortKeys []string
+	for _, c := range monitor.Columns {
+		sortKeys = append(sortKeys, fmt.Sprintf("%c %s", c.Key, strings.ToLower(c.Title)))
+	}
+	fmt.Fprintf(&b, "%d sensors  %s\r\n", len(entries), view.status)
+	fmt.Fprintf(&b, "sort: %s (again to reverse)  j/k select  enter history  q quit\r\n\r\n", strings.Join(sortKeys, ", "))
+	var active []monitor.Alert
+	if alerter != nil {
+		active = alerter.Active()
+	}
+	for _, a := range active {
+		fmt.Fprintf(&b, "\x1b[31m%s\x1b[0m\r\n", monitor.Printable(a.String()))
+	}
+	if len(active) > 0 {
+		b.WriteString("\r\n")
+	}
+	units := deltaT.Units(now)
+	// Scroll so the selected row is visible
+	rows := height - 5 - len(active)
+	if len(active) > 0 {
+		rows--
+	}
+	if len(units) > 0 {
+		// A blank line and the delta-T table below
+		rows -= len(units) + 2
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	start := 0
+	if view.selected >= rows {
+		start = view.selected - rows + 1
+	}
+	end := start + rows
+	if end > len(entries) {
+		end = len(entries)
+	}
+	monitor.RenderTable(&b, entries[start:end], now, view.sortColumn, view.reverse, view.selected-start, celsius)
+	if len(units) > 0 {
+		b.WriteString("\r\n")
+		monitor.RenderDeltaT(&b, units, now, celsius)
+	}
+	out.Write(b.Bytes())
+}
+
+// readKeys sends key presses from a raw terminal, arrows and escape are named
+func readKeys(in io.Reader, keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		s := string(buf[:n])
+		switch s {
+		case "\x1b[A", "\x1bOA":
+			keys <- "up"
+		case "\x1b[B", "\x1bOB":
+			keys <- "down"
+		case "\x1b[C", "\x1bOC":
+			keys <- "right"
+		case "\x1b[D", "\x1bOD":
+			keys <- "left"
+		case "\x1b":
+			keys <- "esc"
+		default:
+			if strings.HasPrefix(s, "\x1b") {
+				// Some other escape sequence
+				continue
+			}
+			for _, c := range s {
+				keys <- string(c)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package calibration corrects temperature probes that read consistently off,
+// with an offset, a linear gain and offset, or a piecewise linear table of
+// measured to reference points.
+package calibration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Point maps a probe's measured value to the reference value
+type Point struct {
+	Measured  float64 `json:"measured"`
+	Reference float64 `json:"reference"`
+}
+
+// Calibration corrects a reading as reference = measured * gain + offset, or by
+// interpolating Points when there are any. Readings outside the table are
+// extrapolated from the nearest segment.
+type Calibration struct {
+	Offset float64 `json:"offset,omitempty"`
+	// Gain defaults to 1
+	Gain   *float64 `json:"gain,omitempty"`
+	Points []Point  `json:"points,omitempty"`
+	// Celsius means the values above are Celsius, otherwise Fahrenheit
+	Celsius bool `json:"celsius,omitempty"`
+}
+
+// Validate checks the calibration can be applied
+func (c *Calibration) Validate() error {
+	if len(c.Points) == 0 {
+		if c.Gain != nil && (*c.Gain <= 0 || math.IsInf(*c.Gain, 0) || math.IsNaN(*c.Gain)) {
+			return errors.New("gain must be a positive number")
+		}
+		return nil
+	}
+	if c.Gain != nil || c.Offset != 0 {
+		return errors.New("can't use points with gain or offset")
+	}
+	if len(c.Points) < 2 {
+		return errors.New("need at least 2 points")
+	}
+	for i := 1; i < len(c.Points); i++ {
+		if c.Points[i].Measured <= c.Points[i-1].Measured {
+			return errors.New("points must be in increasing order of measured value")
+		}
+	}
+	return nil
+}
+
+// Apply returns the corrected temperature, in the calibration's units
+func (c *Calibration) Apply(t sensor.Temperature) sensor.Temperature {
+	t = t.In(c.Celsius)
+	t.Value = c.apply(t.Value)
+	return t
+}
+
+// apply corrects a value already in the calibration's units
+func (c *Calibration) apply(v float64) float64 {
+	if len(c.Points) == 0 {
+		gain := 1.0
+		if c.Gain != nil {
+			gain = *c.Gain
+		}
+		return v*gain + c.Offset
+	}
+	// Index of the segment to use, the first or last outside the table
+	i := sort.Search(len(c.Points), func(i int) bool { return c.Points[i].Measured > v }) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i > len(c.Points)-2 {
+		i = len(c.Points) - 2
+	}
+	a, b := c.Points[i], c.Points[i+1]
+	return a.Reference + (v-a.Measured)*(b.Reference-a.Reference)/(b.Measured-a.Measured)
+}
+
+func (c *Calibration) String() string {
+	unit := "F"
+	if c.Celsius {
+		unit = "C"
+	}
+	if len(c.Points) > 0 {
+		return fmt.Sprintf("%d point table (%s)", len(c.Points), unit)
+	}
+	gain := 1.0
+	if c.Gain != nil {
+		gain = *c.Gain
+	}
+	return fmt.Sprintf("x%.4f %+.3f%s", gain, c.Offset, unit)
+}
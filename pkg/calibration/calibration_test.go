@@ -0,0 +1,79 @@
+package calibration
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, s string) *Calibration {
+	c := &Calibration{}
+	assert.NoError(t, json.Unmarshal([]byte(s), c))
+	return c
+}
+
+func TestApply(t *testing.T) {
+	f := func(v float64) sensor.Temperature { return sensor.Temperature{Value: v} }
+
+	c := parse(t, `{"offset": -1.5}`)
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, 68.5, c.Apply(f(70)).Value)
+
+	c = parse(t, `{"gain": 2, "offset": 1, "celsius": true}`)
+	assert.NoError(t, c.Validate())
+	out := c.Apply(f(68))
+	assert.True(t, out.Celsius)
+	assert.InDelta(t, 41.0, out.Value, 0.0001, "68F is 20C")
+
+	c = parse(t, `{"points": [{"measured": 60, "reference": 61}, {"measured": 70, "reference": 70}, {"measured": 80, "reference": 78}]}`)
+	assert.NoError(t, c.Validate())
+	for measured, expected := range map[float64]float64{60: 61, 65: 65.5, 70: 70, 75: 74, 80: 78, 50: 52, 90: 86} {
+		assert.InDelta(t, expected, c.Apply(f(measured)).Value, 0.0001, "%v", measured)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, bad := range map[string]string{
+		"zero gain":     `{"gain": 0}`,
+		"negative gain": `{"gain": -1}`,
+		"one point":     `{"points": [{"measured": 60, "reference": 61}]}`,
+		"unsorted":      `{"points": [{"measured": 70, "reference": 70}, {"measured": 60, "reference": 61}]}`,
+		"mixed":         `{"offset": 1, "points": [{"measured": 60, "reference": 61}, {"measured": 70, "reference": 70}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, parse(t, bad).Validate())
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	samples, err := ReadSamples(strings.NewReader("reference,measured\n# comment\n60,61\n70, 72\n80,83\n70,71\n"))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 4)
+
+	c, err := Fit(samples, "offset", false)
+	assert.NoError(t, err)
+	assert.Equal(t, -1.75, c.Offset)
+
+	c, err = Fit(samples[:3], "linear", false)
+	assert.NoError(t, err)
+	assert.InDelta(t, 10.0/11, *c.Gain, 0.0001)
+	rms, max := Residuals(c, samples[:3])
+	assert.InDelta(t, 0, rms, 0.0001)
+	assert.InDelta(t, 0, max, 0.0001)
+
+	c, err = Fit(samples, "table", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []Point{{61, 60}, {71, 70}, {72, 70}, {83, 80}}, c.Points)
+	assert.NoError(t, c.Validate())
+
+	_, err = Fit(samples[:1], "linear", false)
+	assert.Error(t, err)
+	_, err = Fit(samples, "cubic", false)
+	assert.Error(t, err)
+	_, err = ReadSamples(strings.NewReader("60,61\nabc,1\n"))
+	assert.Error(t, err)
+}
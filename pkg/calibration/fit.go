@@ -0,0 +1,122 @@
+package calibration
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FitModes are the kinds of calibration Fit can compute
+var FitModes = []string{"offset", "linear", "table"}
+
+// ReadSamples reads reference,measured pairs from CSV. A first line that isn't
+// numbers is taken as a header, blank lines and lines starting with # are skipped.
+func ReadSamples(r io.Reader) ([]Point, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var samples []Point
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading samples: %w", err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: need reference and measured values", line)
+		}
+		ref, err1 := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		measured, err2 := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err1 != nil || err2 != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid number", line)
+		}
+		samples = append(samples, Point{Measured: measured, Reference: ref})
+	}
+	return samples, nil
+}
+
+// Fit computes a calibration of the given mode from samples:
+//
+//	offset  the mean difference between reference and measured
+//	linear  a least squares line
+//	table   a point per distinct measured value, averaging references
+func Fit(samples []Point, mode string, celsius bool) (*Calibration, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no samples")
+	}
+	c := &Calibration{Celsius: celsius}
+	switch mode {
+	case "offset":
+		sum := 0.0
+		for _, s := range samples {
+			sum += s.Reference - s.Measured
+		}
+		c.Offset = sum / float64(len(samples))
+	case "linear":
+		if len(samples) < 2 {
+			return nil, errors.New("linear fit needs at least 2 samples")
+		}
+		n := float64(len(samples))
+		var sx, sy, sxx, sxy float64
+		for _, s := range samples {
+			sx += s.Measured
+			sy += s.Reference
+			sxx += s.Measured * s.Measured
+			sxy += s.Measured * s.Reference
+		}
+		d := n*sxx - sx*sx
+		if d == 0 {
+			return nil, errors.New("linear fit needs samples at more than one measured value")
+		}
+		gain := (n*sxy - sx*sy) / d
+		if gain <= 0 {
+			return nil, fmt.Errorf("fitted gain [%.4f] isn't positive, check the samples", gain)
+		}
+		c.Gain = &gain
+		c.Offset = (sy - gain*sx) / n
+	case "table":
+		sorted := append([]Point(nil), samples...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Measured < sorted[j].Measured })
+		for i := 0; i < len(sorted); {
+			j, sum := i, 0.0
+			for ; j < len(sorted) && sorted[j].Measured == sorted[i].Measured; j++ {
+				sum += sorted[j].Reference
+			}
+			c.Points = append(c.Points, Point{Measured: sorted[i].Measured, Reference: sum / float64(j-i)})
+			i = j
+		}
+		if len(c.Points) < 2 {
+			return nil, errors.New("table needs samples at more than one measured value")
+		}
+	default:
+		return nil, fmt.Errorf("invalid fit mode [%s]", mode)
+	}
+	return c, nil
+}
+
+// Residuals returns the RMS and largest absolute error of c against samples,
+// nil c means uncalibrated
+func Residuals(c *Calibration, samples []Point) (float64, float64) {
+	var sumSq, max float64
+	for _, s := range samples {
+		v := s.Measured
+		if c != nil {
+			v = c.apply(v)
+		}
+		e := math.Abs(v - s.Reference)
+		sumSq += e * e
+		max = math.Max(max, e)
+	}
+	return math.Sqrt(sumSq / float64(len(samples))), max
+}
@@ -0,0 +1,59 @@
+// Package export writes observed readings in formats other tools can ingest
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"sync"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+)
+
+// Exporter writes each observation somewhere
+type Exporter interface {
+	Export(o monitor.Observation) error
+	Close() error
+}
+
+// CSVExporter writes observations as CSV in monitor.CSVHeader order, with the
+// header first unless it's appending to existing rows. Each row is flushed as
+// it's written.
+type CSVExporter struct {
+	lock   sync.Mutex
+	w      *csv.Writer
+	closer io.Closer
+	header bool
+}
+
+// NewCSVExporter writes to w, closing it on Close if it's an io.Closer. header
+// is whether to start with the header row.
+func NewCSVExporter(w io.Writer, header bool) *CSVExporter {
+	e := &CSVExporter{w: csv.NewWriter(w), header: !header}
+	e.closer, _ = w.(io.Closer)
+	return e
+}
+
+func (e *CSVExporter) Export(o monitor.Observation) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.header {
+		err := e.w.Write(monitor.CSVHeader)
+		if err != nil {
+			return err
+		}
+		e.header = true
+	}
+	err := e.w.Write(o.CSVRecord())
+	if err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *CSVExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
@@ -0,0 +1,109 @@
+package export
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func observation() monitor.Observation {
+	return monitor.Observation{
+		Time:        time.Unix(1651442400, 5),
+		MAC:         "0ae632b7095b",
+		Name:        "Living Room, east",
+		Type:        sensor.SensorType_REMOTE,
+		UnitID:      1,
+		Message:     sensor.MessageType_DATA,
+		Code:        120,
+		Temperature: 68,
+		Battery:     95,
+		PowerSource: int32(sensor.PowerSource_BATTERY),
+		Seq:         12,
+		Signature:   sensor.SignatureValid,
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	assert.Equal(t,
+		`tstat_sensor,mac=0ae632b7095b,name=Living\ Room\,\ east,type=REMOTE,unit_id=1,message=DATA,signature=valid temperature_f=68,code=120i,battery=95i,power_source="BATTERY",seq=12i 1651442400000000005`,
+		LineProtocol(observation()))
+
+	o := observation()
+	o.Name = ""
+	o.Sent = true
+	o.Temperature = 69.8
+	assert.Equal(t,
+		`tstat_sensor,mac=0ae632b7095b,type=REMOTE,unit_id=1,message=DATA,signature=valid,sent=true temperature_f=69.8,code=120i,battery=95i,power_source="BATTERY",seq=12i 1651442400000000005`,
+		LineProtocol(o), "Empty tags are left out")
+
+	// Names come from unauthenticated packets
+	o = observation()
+	o.Name = "x\nevil,name=y z=1 1\r"
+	line := LineProtocol(o)
+	assert.NotContains(t, line, "\n")
+	assert.NotContains(t, line, "\r")
+	assert.Contains(t, line, `,name=x\ evil\,name\=y\ z\=1\ 1\ ,type=REMOTE,`)
+	o.Name = `trail\`
+	assert.Contains(t, LineProtocol(o), `,name=trail\\,type=REMOTE,`, "A trailing backslash doesn't escape the comma")
+}
+
+func TestCSVExporter(t *testing.T) {
+	var b bytes.Buffer
+	e := NewCSVExporter(&b, true)
+	assert.NoError(t, e.Export(observation()))
+	assert.NoError(t, e.Export(observation()))
+	assert.Equal(t, "time,mac,name,type,unit_id,message,code,temperature_f,battery,power_source,seq,signature,from,sent\n"+
+		"2022-05-01T22:00:00.000000005Z,0ae632b7095b,\"Living Room, east\",REMOTE,1,DATA,120,68,95,BATTERY,12,valid,,false\n"+
+		"2022-05-01T22:00:00.000000005Z,0ae632b7095b,\"Living Room, east\",REMOTE,1,DATA,120,68,95,BATTERY,12,valid,,false\n", b.String())
+
+	b.Reset()
+	e = NewCSVExporter(&b, false)
+	assert.NoError(t, e.Export(observation()))
+	assert.NotContains(t, b.String(), "time,", "No header when appending")
+}
+
+func TestInfluxHTTPExporter(t *testing.T) {
+	var auth string
+	status := http.StatusNoContent
+	bodies := make(chan string, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+		bodies <- string(data)
+		<-release
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "bucket not found"}`))
+	}))
+	defer server.Close()
+
+	e := newInfluxHTTPExporter(server.URL+"/api/v2/write?bucket=sensors", "secret", 2)
+	first, second := observation(), observation()
+	second.Seq++
+	line := LineProtocol(first)
+	assert.NoError(t, e.Export(first))
+	assert.Equal(t, line+"\n", <-bodies)
+	assert.Equal(t, "Token secret", auth)
+
+	// The first write is stuck, the rest queue up
+	assert.NoError(t, e.Export(second))
+	assert.NoError(t, e.Export(second))
+	assert.Error(t, e.Export(second), "Queue is full")
+	close(release)
+	assert.NoError(t, e.Close())
+	assert.Equal(t, strings.Repeat(LineProtocol(second)+"\n", 2), <-bodies, "Queued lines are written together")
+	assert.Error(t, e.Export(first), "Closed")
+
+	status = http.StatusNotFound
+	err := e.write([]string{line})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bucket not found")
+}
@@ -0,0 +1,218 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+)
+
+// Measurement is the InfluxDB measurement readings are written to
+const Measurement = "tstat_sensor"
+
+// DefaultInfluxTimeout bounds each write to an InfluxDB HTTP endpoint
+const DefaultInfluxTimeout = 10 * time.Second
+
+var (
+	// Line protocol can't escape line breaks, they're replaced with escaped
+	// spaces. Escaping backslashes keeps a trailing one from escaping the next
+	// tag's comma.
+	tagEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\r", `\ `, "\n", `\ `, `\`, `\\`)
+	stringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// LineProtocol formats o as an InfluxDB line protocol line, without the
+// trailing newline. The sensor's identity is tags and the reading is fields:
+//
+//	tstat_sensor,mac=...,name=Living,type=REMOTE,unit_id=1,message=DATA,signature=valid temperature_f=68,code=120i,battery=100i,power_source="BATTERY",seq=12i 1651442400000000000
+func LineProtocol(o monitor.Observation) string {
+	var b strings.Builder
+	b.WriteString(Measurement)
+	tag := func(k, v string) {
+		// Empty tag values aren't allowed
+		if v == "" {
+			return
+		}
+		b.WriteString("," + k + "=" + tagEscaper.Replace(v))
+	}
+	tag("mac", o.MAC)
+	tag("name", o.Name)
+	tag("type", o.Type.String())
+	tag("unit_id", strconv.Itoa(int(o.UnitID)))
+	tag("message", o.Message.String())
+	tag("signature", o.Signature.String())
+	if o.Sent {
+		tag("sent", "true")
+	}
+	b.WriteString(" temperature_f=" + strconv.FormatFloat(o.Temperature, 'f', -1, 64))
+	b.WriteString(",code=" + strconv.Itoa(int(o.Code)) + "i")
+	b.WriteString(",battery=" + strconv.Itoa(int(o.Battery)) + "i")
+	b.WriteString(`,power_source="` + stringEscaper.Replace(sensor.PowerSource(o.PowerSource).String()) + `"`)
+	b.WriteString(",seq=" + strconv.Itoa(int(o.Seq)) + "i")
+	b.WriteString(" " + strconv.FormatInt(o.Time.UnixNano(), 10))
+	return b.String()
+}
+
+// LineExporter writes line protocol to a writer, one line per observation
+type LineExporter struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewLineExporter writes to w, closing it on Close if it's an io.Closer
+func NewLineExporter(w io.Writer) *LineExporter {
+	e := &LineExporter{w: w}
+	e.closer, _ = w.(io.Closer)
+	return e
+}
+
+func (e *LineExporter) Export(o monitor.Observation) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	_, err := io.WriteString(e.w, LineProtocol(o)+"\n")
+	return err
+}
+
+func (e *LineExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+const (
+	// influxQueueSize is how many lines wait for InfluxDB before readings are dropped
+	influxQueueSize = 1000
+	// influxBatchSize is the most lines written in a single request
+	influxBatchSize = 500
+)
+
+// InfluxHTTPExporter posts line protocol to an InfluxDB compatible write
+// endpoint, like http://localhost:8086/write?db=sensors (1.x) or
+// http://localhost:8086/api/v2/write?org=home&bucket=sensors&precision=ns (2.x)
+//
+// Writes happen in the background so a slow server never holds up the caller.
+// Lines queued while a write is in progress are sent together in the next one,
+// if the queue fills readings are dropped.
+type InfluxHTTPExporter struct {
+	URL string
+	// Token is sent as "Authorization: Token <token>" if set
+	Token   string
+	Timeout time.Duration
+	Client  *http.Client
+
+	lock   sync.Mutex
+	closed bool
+	queue  chan string
+	done   chan struct{}
+}
+
+// NewInfluxHTTPExporter starts writing to url, Close flushes what's queued
+func NewInfluxHTTPExporter(url string, token string) *InfluxHTTPExporter {
+	return newInfluxHTTPExporter(url, token, influxQueueSize)
+}
+
+func newInfluxHTTPExporter(url string, token string, queueSize int) *InfluxHTTPExporter {
+	e := &InfluxHTTPExporter{
+		URL:   url,
+		Token: token,
+		queue: make(chan string, queueSize),
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues o to be written, it only fails if the queue is full
+func (e *InfluxHTTPExporter) Export(o monitor.Observation) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return fmt.Errorf("exporter is closed")
+	}
+	select {
+	case e.queue <- LineProtocol(o):
+		return nil
+	default:
+		return fmt.Errorf("queue for [%s] is full, dropping reading", e.URL)
+	}
+}
+
+func (e *InfluxHTTPExporter) run() {
+	defer close(e.done)
+	for line := range e.queue {
+		batch := []string{line}
+	drain:
+		for len(batch) < influxBatchSize {
+			select {
+			case line, ok := <-e.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, line)
+			default:
+				break drain
+			}
+		}
+		err := e.write(batch)
+		if err != nil {
+			log.Error().Err(err).Int("lines", len(batch)).Msg("Error exporting to InfluxDB")
+		}
+	}
+}
+
+// write posts lines in a single request
+func (e *InfluxHTTPExporter) write(lines []string) error {
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = DefaultInfluxTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewBufferString(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.Token != "" {
+		req.Header.Set("Authorization", "Token "+e.Token)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing to [%s]: %w", e.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("error writing to [%s]: %s %s", e.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close writes anything still queued and stops
+func (e *InfluxHTTPExporter) Close() error {
+	e.lock.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.lock.Unlock()
+	<-e.done
+	return nil
+}
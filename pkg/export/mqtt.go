@@ -0,0 +1,311 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/mqtt"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultMQTTPrefix is the start of the state topics, <prefix>/<mac>/state
+	DefaultMQTTPrefix = "tstat-sensor"
+	// DefaultDiscoveryPrefix is Home Assistant's default discovery prefix
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	mqttConnectTimeout = 10 * time.Second
+	// mqttRetry is how long to wait after failing to connect before trying again
+	mqttRetry = 30 * time.Second
+	// mqttQueueSize is how many readings wait to be published before readings
+	// are dropped
+	mqttQueueSize = 100
+)
+
+// publisher is the part of mqtt.Client the exporter uses
+type publisher interface {
+	Publish(m mqtt.Message) error
+	Err() error
+	Close() error
+}
+
+// MQTTExporter publishes readings from sensors with valid signatures to MQTT,
+// along with Home Assistant discovery config for each sensor. Only messages
+// signed by a key from the key store (sensor.SignatureValid) are published,
+// anyone on the network can send the others, pairing messages included. A
+// reading is only published if its sequence number is newer than the last one
+// published for its MAC, so a captured message can't be replayed.
+//
+// Each sensor's state is a retained JSON document at <prefix>/<mac>/state and
+// <prefix>/status is online while connected (offline is the connection's will).
+//
+// Readings are published in the background so a slow or unreachable broker
+// never holds up the caller, if the queue fills readings are dropped.
+type MQTTExporter struct {
+	Options mqtt.Options
+	Prefix  string
+	// DiscoveryPrefix is where Home Assistant discovery config is published, empty disables discovery
+	DiscoveryPrefix string
+
+	// queueLock guards closing the queue and lastSeq, lock everything send
+	// touches
+	queueLock sync.Mutex
+	closed    bool
+	lastSeq   map[string]int32
+	queue     chan monitor.Observation
+	done      chan struct{}
+	lock      sync.Mutex
+	client    publisher
+	retry     time.Time
+	announced map[string]string
+	connect   func(ctx context.Context, opts mqtt.Options) (publisher, error)
+}
+
+// NewMQTTExporter publishes to the broker in opts. It connects on the first
+// reading, and again whenever the connection drops.
+func NewMQTTExporter(opts mqtt.Options, prefix string, discoveryPrefix string) *MQTTExporter {
+	e := &MQTTExporter{
+		Options:         opts,
+		Prefix:          prefix,
+		DiscoveryPrefix: discoveryPrefix,
+		lastSeq:         make(map[string]int32),
+		queue:           make(chan monitor.Observation, mqttQueueSize),
+		done:            make(chan struct{}),
+	}
+	e.Options.Will = &mqtt.Message{Topic: e.statusTopic(), Payload: []byte("offline"), Retain: true}
+	go e.run()
+	return e
+}
+
+func (e *MQTTExporter) statusTopic() string {
+	return e.Prefix + "/status"
+}
+
+// Export queues o to be published, it only fails if the queue is full
+func (e *MQTTExporter) Export(o monitor.Observation) error {
+	if o.Message != sensor.MessageType_DATA || o.Signature != sensor.SignatureValid {
+		return nil
+	}
+	e.queueLock.Lock()
+	defer e.queueLock.Unlock()
+	if e.closed {
+		return fmt.Errorf("exporter is closed")
+	}
+	if last, ok := e.lastSeq[o.MAC]; ok && o.Seq <= last {
+		log.Debug().Str("mac", o.MAC).Int32("seq", o.Seq).Msg("Not publishing a replayed or repeated reading")
+		return nil
+	}
+	e.lastSeq[o.MAC] = o.Seq
+	select {
+	case e.queue <- o:
+		return nil
+	default:
+		return fmt.Errorf("mqtt queue is full, dropping reading")
+	}
+}
+
+func (e *MQTTExporter) run() {
+	defer close(e.done)
+	for o := range e.queue {
+		err := e.send(o)
+		if err != nil {
+			log.Error().Err(err).Msg("Error publishing to MQTT")
+		}
+	}
+}
+
+// send connects if needed and publishes o
+func (e *MQTTExporter) send(o monitor.Observation) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	err := e.ensureConnected()
+	if err != nil {
+		return err
+	}
+	err = e.publish(o)
+	if err != nil {
+		// Reconnect on the next reading
+		e.client.Close()
+		e.client = nil
+		return err
+	}
+	return nil
+}
+
+func (e *MQTTExporter) ensureConnected() error {
+	if e.client != nil {
+		err := e.client.Err()
+		if err == nil {
+			return nil
+		}
+		log.Warn().Err(err).Msg("Lost MQTT connection")
+		e.client = nil
+	}
+	if time.Now().Before(e.retry) {
+		return fmt.Errorf("not connected to mqtt broker, retrying at [%s]", e.retry.Format(time.RFC3339))
+	}
+	connect := e.connect
+	if connect == nil {
+		connect = func(ctx context.Context, opts mqtt.Options) (publisher, error) {
+			return mqtt.Connect(ctx, opts)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mqttConnectTimeout)
+	defer cancel()
+	client, err := connect(ctx, e.Options)
+	if err != nil {
+		e.retry = time.Now().Add(mqttRetry)
+		return err
+	}
+	err = client.Publish(mqtt.Message{Topic: e.statusTopic(), Payload: []byte("online"), Retain: true})
+	if err != nil {
+		client.Close()
+		e.retry = time.Now().Add(mqttRetry)
+		return err
+	}
+	log.Info().Msg("Connected to MQTT broker")
+	e.client = client
+	// Discovery config is retained, but republishing after a reconnect
+	// restores it if the broker lost it
+	e.announced = make(map[string]string)
+	return nil
+}
+
+// mqttState is the JSON published to a sensor's state topic
+type mqttState struct {
+	Time        time.Time         `json:"time"`
+	Name        string            `json:"name"`
+	Type        sensor.SensorType `json:"type"`
+	UnitID      int32             `json:"unit_id"`
+	Temperature float64           `json:"temperature"`
+	Battery     int32             `json:"battery"`
+	PowerSource string            `json:"power_source"`
+	Seq         int32             `json:"seq"`
+}
+
+func (e *MQTTExporter) publish(o monitor.Observation) error {
+	id := topicSafe(o.MAC)
+	stateTopic := e.Prefix + "/" + id + "/state"
+	if e.DiscoveryPrefix != "" && e.announced[id] != o.Name {
+		for _, m := range discovery(e.DiscoveryPrefix, e.statusTopic(), stateTopic, id, o) {
+			err := e.client.Publish(m)
+			if err != nil {
+				return fmt.Errorf("error publishing discovery: %w", err)
+			}
+		}
+		e.announced[id] = o.Name
+	}
+	state, err := json.Marshal(mqttState{
+		Time:        o.Time,
+		Name:        o.Name,
+		Type:        o.Type,
+		UnitID:      o.UnitID,
+		Temperature: o.Temperature,
+		Battery:     o.Battery,
+		PowerSource: sensor.PowerSource(o.PowerSource).String(),
+		Seq:         o.Seq,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling state: %w", err)
+	}
+	err = e.client.Publish(mqtt.Message{Topic: stateTopic, Payload: state, Retain: true})
+	if err != nil {
+		return fmt.Errorf("error publishing state: %w", err)
+	}
+	return nil
+}
+
+// Close publishes anything still queued, marks the exporter offline and
+// disconnects
+func (e *MQTTExporter) Close() error {
+	e.queueLock.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.queueLock.Unlock()
+	<-e.done
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.client == nil {
+		return nil
+	}
+	e.client.Publish(mqtt.Message{Topic: e.statusTopic(), Payload: []byte("offline"), Retain: true})
+	err := e.client.Close()
+	e.client = nil
+	return err
+}
+
+// hassEntity is a Home Assistant MQTT discovery config
+type hassEntity struct {
+	Name              string     `json:"name"`
+	UniqueID          string     `json:"unique_id"`
+	StateTopic        string     `json:"state_topic"`
+	ValueTemplate     string     `json:"value_template"`
+	AvailabilityTopic string     `json:"availability_topic"`
+	DeviceClass       string     `json:"device_class,omitempty"`
+	StateClass        string     `json:"state_class,omitempty"`
+	Unit              string     `json:"unit_of_measurement,omitempty"`
+	EntityCategory    string     `json:"entity_category,omitempty"`
+	Device            hassDevice `json:"device"`
+}
+
+type hassDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discovery is the Home Assistant discovery config for a sensor's temperature,
+// battery and power source
+func discovery(prefix string, statusTopic string, stateTopic string, id string, o monitor.Observation) []mqtt.Message {
+	node := "tstat_" + id
+	device := hassDevice{
+		Identifiers:  []string{node},
+		Name:         o.Name,
+		Manufacturer: "Venstar",
+		Model:        "ACC-TSENWIFIPRO",
+	}
+	entities := []struct {
+		object string
+		hassEntity
+	}{
+		{"temperature", hassEntity{Name: "Temperature", DeviceClass: "temperature", StateClass: "measurement", Unit: "°F", ValueTemplate: "{{ value_json.temperature }}"}},
+		{"battery", hassEntity{Name: "Battery", DeviceClass: "battery", StateClass: "measurement", Unit: "%", EntityCategory: "diagnostic", ValueTemplate: "{{ value_json.battery }}"}},
+		{"power_source", hassEntity{Name: "Power source", EntityCategory: "diagnostic", ValueTemplate: "{{ value_json.power_source }}"}},
+	}
+	var msgs []mqtt.Message
+	for _, entity := range entities {
+		c := entity.hassEntity
+		c.UniqueID = node + "_" + entity.object
+		c.StateTopic = stateTopic
+		c.AvailabilityTopic = statusTopic
+		c.Device = device
+		payload, _ := json.Marshal(c)
+		msgs = append(msgs, mqtt.Message{
+			Topic:   prefix + "/sensor/" + node + "/" + entity.object + "/config",
+			Payload: payload,
+			Retain:  true,
+		})
+	}
+	return msgs
+}
+
+// topicSafe replaces anything but letters, digits, - and _ so a MAC can't
+// inject topic levels or wildcards
+func topicSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
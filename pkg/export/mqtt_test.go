@@ -0,0 +1,153 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/mqtt"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePublisher struct {
+	published []mqtt.Message
+	// err fails publishing without Err noticing, like a write to a dead connection
+	err error
+}
+
+func (p *fakePublisher) Publish(m mqtt.Message) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, m)
+	return nil
+}
+
+func (p *fakePublisher) Err() error {
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func (p *fakePublisher) topics() []string {
+	var topics []string
+	for _, m := range p.published {
+		topics = append(topics, m.Topic)
+	}
+	p.published = nil
+	return topics
+}
+
+func TestMQTTExporter(t *testing.T) {
+	var p *fakePublisher
+	connects := 0
+	e := NewMQTTExporter(mqtt.Options{}, DefaultMQTTPrefix, DefaultDiscoveryPrefix)
+	e.connect = func(ctx context.Context, opts mqtt.Options) (publisher, error) {
+		assert.Equal(t, "tstat-sensor/status", opts.Will.Topic)
+		connects++
+		p = &fakePublisher{}
+		return p, nil
+	}
+
+	for _, sig := range []sensor.SignatureStatus{sensor.SignatureNoKey, sensor.SignatureInvalid, sensor.SignaturePair, sensor.SignaturePairKey} {
+		o := observation()
+		o.Signature = sig
+		assert.NoError(t, e.Export(o))
+	}
+	assert.Empty(t, e.queue, "Unverified readings aren't published")
+
+	assert.NoError(t, e.send(observation()))
+	assert.Equal(t, []string{
+		"tstat-sensor/status",
+		"homeassistant/sensor/tstat_0ae632b7095b/temperature/config",
+		"homeassistant/sensor/tstat_0ae632b7095b/battery/config",
+		"homeassistant/sensor/tstat_0ae632b7095b/power_source/config",
+		"tstat-sensor/0ae632b7095b/state",
+	}, p.topics())
+
+	assert.NoError(t, e.send(observation()))
+	last := p.published[0]
+	assert.Equal(t, []string{"tstat-sensor/0ae632b7095b/state"}, p.topics(), "Discovery is only sent once")
+	assert.True(t, last.Retain)
+	var state map[string]interface{}
+	assert.NoError(t, json.Unmarshal(last.Payload, &state))
+	assert.Equal(t, 68.0, state["temperature"])
+	assert.Equal(t, 95.0, state["battery"])
+	assert.Equal(t, "BATTERY", state["power_source"])
+
+	p.err = errors.New("broken pipe")
+	assert.Error(t, e.send(observation()))
+	assert.NoError(t, e.send(observation()))
+	assert.Equal(t, 2, connects, "Reconnects after an error")
+	assert.Len(t, p.topics(), 5, "Discovery is resent after reconnecting")
+
+	o := observation()
+	o.MAC = "aa/#"
+	assert.NoError(t, e.send(o))
+	assert.Contains(t, p.topics(), "tstat-sensor/aa__/state")
+}
+
+func TestMQTTExporterQueue(t *testing.T) {
+	p := &fakePublisher{}
+	connecting := make(chan struct{})
+	release := make(chan struct{})
+	e := NewMQTTExporter(mqtt.Options{}, DefaultMQTTPrefix, "")
+	e.connect = func(ctx context.Context, opts mqtt.Options) (publisher, error) {
+		close(connecting)
+		<-release
+		return p, nil
+	}
+
+	seq := int32(0)
+	next := func() monitor.Observation {
+		o := observation()
+		seq++
+		o.Seq = seq
+		return o
+	}
+	// The first reading is stuck connecting, the rest queue up
+	assert.NoError(t, e.Export(next()))
+	<-connecting
+	for i := 0; i < mqttQueueSize; i++ {
+		assert.NoError(t, e.Export(next()))
+	}
+	assert.Error(t, e.Export(next()), "Queue is full")
+	close(release)
+	assert.NoError(t, e.Close())
+	topics := p.topics()
+	assert.Len(t, topics, mqttQueueSize+3, "Queued readings are published on Close")
+	assert.Equal(t, "tstat-sensor/status", topics[0])
+	assert.Equal(t, "tstat-sensor/status", topics[len(topics)-1], "Offline")
+	assert.Error(t, e.Export(next()), "Closed")
+}
+
+func TestMQTTExporterReplay(t *testing.T) {
+	p := &fakePublisher{}
+	e := NewMQTTExporter(mqtt.Options{}, DefaultMQTTPrefix, "")
+	e.connect = func(ctx context.Context, opts mqtt.Options) (publisher, error) {
+		return p, nil
+	}
+	o := observation()
+	assert.NoError(t, e.Export(o))
+	assert.NoError(t, e.Export(o))
+	o.Seq--
+	assert.NoError(t, e.Export(o))
+	other := observation()
+	other.MAC = "0ae632b7095c"
+	assert.NoError(t, e.Export(other))
+	o.Seq += 2
+	assert.NoError(t, e.Export(o))
+	assert.NoError(t, e.Close())
+	assert.Equal(t, []string{
+		"tstat-sensor/status",
+		"tstat-sensor/0ae632b7095b/state",
+		"tstat-sensor/0ae632b7095c/state",
+		"tstat-sensor/0ae632b7095b/state",
+		"tstat-sensor/status",
+	}, p.topics(), "Only newer sequence numbers are published, per MAC")
+}
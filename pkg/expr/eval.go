@@ -0,0 +1,304 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type node interface {
+	eval(env *Env) (interface{}, error)
+}
+
+type numNode struct{ v float64 }
+
+func (n *numNode) eval(env *Env) (interface{}, error) { return n.v, nil }
+
+type boolNode struct{ v bool }
+
+func (n *boolNode) eval(env *Env) (interface{}, error) { return n.v, nil }
+
+type varNode struct{ name string }
+
+func (n *varNode) eval(env *Env) (interface{}, error) {
+	if v, ok := env.Values[n.name]; ok {
+		return v, nil
+	}
+	if l, ok := env.Lists[n.name]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("no value for [%s]", n.name)
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(env *Env) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "not" {
+		b, err := asBool(v, "not")
+		return !b, err
+	}
+	f, err := asNumber(v, n.op)
+	return -f, err
+}
+
+type binaryNode struct {
+	op   string
+	l, r node
+}
+
+func (n *binaryNode) eval(env *Env) (interface{}, error) {
+	lv, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "and" || n.op == "or" {
+		l, err := asBool(lv, n.op)
+		if err != nil {
+			return nil, err
+		}
+		// Short circuit
+		if (n.op == "and" && !l) || (n.op == "or" && l) {
+			return l, nil
+		}
+		rv, err := n.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(rv, n.op)
+	}
+	rv, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	l, err := asNumber(lv, n.op)
+	if err != nil {
+		return nil, err
+	}
+	r, err := asNumber(rv, n.op)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	}
+	return nil, fmt.Errorf("unknown operator [%s]", n.op)
+}
+
+type condNode struct {
+	then, cond, els node
+}
+
+func (n *condNode) eval(env *Env) (interface{}, error) {
+	cv, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	c, err := asBool(cv, "if")
+	if err != nil {
+		return nil, err
+	}
+	if c {
+		return n.then.eval(env)
+	}
+	return n.els.eval(env)
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n *callNode) eval(env *Env) (interface{}, error) {
+	f := functions[n.name]
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := f.fn(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return v, nil
+}
+
+func asNumber(v interface{}, op string) (float64, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s needs a number, got %s", op, typeName(v))
+	}
+	return f, nil
+}
+
+func asBool(v interface{}, op string) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs a boolean, got %s", op, typeName(v))
+	}
+	return b, nil
+}
+
+type function struct {
+	minArgs int
+	// maxArgs of -1 means any number
+	maxArgs int
+	fn      func(env *Env, args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"mean":   {1, -1, aggregate(mean)},
+	"avg":    {1, -1, aggregate(mean)},
+	"median": {1, -1, aggregate(median)},
+	"min":    {1, -1, aggregate(minimum)},
+	"max":    {1, -1, aggregate(maximum)},
+	"count": {1, -1, func(env *Env, args []interface{}) (interface{}, error) {
+		values, err := flatten(args)
+		return float64(len(values)), err
+	}},
+	"clamp": {3, 3, func(env *Env, args []interface{}) (interface{}, error) {
+		x, lo, hi, err := numbers3(args)
+		if err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			return nil, errors.New("low bound is above high bound")
+		}
+		return math.Min(math.Max(x, lo), hi), nil
+	}},
+	"abs": {1, 1, func(env *Env, args []interface{}) (interface{}, error) {
+		x, err := asNumber(args[0], "abs")
+		return math.Abs(x), err
+	}},
+	"round": {1, 1, func(env *Env, args []interface{}) (interface{}, error) {
+		x, err := asNumber(args[0], "round")
+		return math.Round(x), err
+	}},
+	// Time of day in the local time zone
+	"hour": {0, 0, func(env *Env, args []interface{}) (interface{}, error) {
+		return float64(env.Now.Hour()), nil
+	}},
+	"minute": {0, 0, func(env *Env, args []interface{}) (interface{}, error) {
+		return float64(env.Now.Minute()), nil
+	}},
+	// Fractional hours since midnight, 22.5 is 10:30pm
+	"time": {0, 0, func(env *Env, args []interface{}) (interface{}, error) {
+		return float64(env.Now.Hour()) + float64(env.Now.Minute())/60, nil
+	}},
+	// 0 is Sunday
+	"weekday": {0, 0, func(env *Env, args []interface{}) (interface{}, error) {
+		return float64(env.Now.Weekday()), nil
+	}},
+}
+
+// flatten turns number and list arguments into a single list
+func flatten(args []interface{}) ([]float64, error) {
+	var values []float64
+	for _, a := range args {
+		switch v := a.(type) {
+		case float64:
+			values = append(values, v)
+		case []float64:
+			values = append(values, v...)
+		default:
+			return nil, fmt.Errorf("needs numbers or lists, got %s", typeName(a))
+		}
+	}
+	return values, nil
+}
+
+func aggregate(f func([]float64) float64) func(env *Env, args []interface{}) (interface{}, error) {
+	return func(env *Env, args []interface{}) (interface{}, error) {
+		values, err := flatten(args)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return nil, errors.New("no values")
+		}
+		return f(values), nil
+	}
+}
+
+func numbers3(args []interface{}) (float64, float64, float64, error) {
+	var r [3]float64
+	for i := range r {
+		f, err := asNumber(args[i], "clamp")
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		r[i] = f
+	}
+	return r[0], r[1], r[2], nil
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func minimum(values []float64) float64 {
+	r := values[0]
+	for _, v := range values[1:] {
+		r = math.Min(r, v)
+	}
+	return r
+}
+
+func maximum(values []float64) float64 {
+	r := values[0]
+	for _, v := range values[1:] {
+		r = math.Max(r, v)
+	}
+	return r
+}
@@ -0,0 +1,161 @@
+// Package expr is a small, safe expression language for computing a virtual
+// sensor's value from named inputs, e.g.
+//
+//	max(living, kitchen) - 1.0
+//	mean(bedrooms) if hour() >= 22 or hour() < 7 else living
+//
+// Expressions can only do arithmetic, comparisons and call the built in
+// functions, there are no loops or assignments so evaluation always terminates.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// MaxLength is the longest expression Parse accepts
+const MaxLength = 4096
+
+// maxDepth limits nesting so hostile input can't exhaust the stack
+const maxDepth = 64
+
+// Env is what an expression is evaluated against
+type Env struct {
+	// Values are single named values, usually inputs
+	Values map[string]float64
+	// Lists are named groups of values for the aggregate functions
+	Lists map[string][]float64
+	// Now is used by the time of day functions
+	Now time.Time
+}
+
+// Expr is a parsed expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse parses src
+func Parse(src string) (*Expr, error) {
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("expression longer than %d characters", MaxLength)
+	}
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", p.peek(), p.peek().pos)
+	}
+	err = check(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars returns the names the expression refers to, sorted
+func (e *Expr) Vars() []string {
+	seen := make(map[string]bool)
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *varNode:
+			seen[n.name] = true
+		case *callNode:
+			for _, a := range n.args {
+				walk(a)
+			}
+		case *unaryNode:
+			walk(n.x)
+		case *binaryNode:
+			walk(n.l)
+			walk(n.r)
+		case *condNode:
+			walk(n.then)
+			walk(n.cond)
+			walk(n.els)
+		}
+	}
+	walk(e.root)
+	r := make([]string, 0, len(seen))
+	for name := range seen {
+		r = append(r, name)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Eval evaluates the expression, the result must be a finite number
+func (e *Expr) Eval(env Env) (float64, error) {
+	v, err := e.root.eval(&env)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression result is %s, not a number", typeName(v))
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("expression result is not a finite number")
+	}
+	return f, nil
+}
+
+// check makes sure every function exists and is called with a valid argument count
+func check(n node) error {
+	switch n := n.(type) {
+	case *callNode:
+		f, ok := functions[n.name]
+		if !ok {
+			return fmt.Errorf("unknown function [%s]", n.name)
+		}
+		if len(n.args) < f.minArgs || (f.maxArgs >= 0 && len(n.args) > f.maxArgs) {
+			return fmt.Errorf("wrong number of arguments to %s()", n.name)
+		}
+		for _, a := range n.args {
+			if err := check(a); err != nil {
+				return err
+			}
+		}
+	case *unaryNode:
+		return check(n.x)
+	case *binaryNode:
+		if err := check(n.l); err != nil {
+			return err
+		}
+		return check(n.r)
+	case *condNode:
+		for _, c := range []node{n.then, n.cond, n.els} {
+			if err := check(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []float64:
+		return "a list"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,108 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Values: map[string]float64{"living": 70, "kitchen": 72.5, "bed1": 66, "bed2": 68},
+		Lists:  map[string][]float64{"bedrooms": {66, 68, 67}},
+		Now:    time.Date(2022, 5, 1, 23, 30, 0, 0, time.Local),
+	}
+	test := func(src string, expected float64) {
+		t.Run(src, func(t *testing.T) {
+			e, err := Parse(src)
+			assert.NoError(t, err)
+			v, err := e.Eval(env)
+			assert.NoError(t, err)
+			assert.InDelta(t, expected, v, 1e-9)
+		})
+	}
+	test("70", 70)
+	test("1 + 2 * 3", 7)
+	test("(1 + 2) * 3", 9)
+	test("-living + 100", 30)
+	test("--1", 1)
+	test("7 % 4", 3)
+	test("max(living, kitchen) - 1.0", 71.5)
+	test("min(living, kitchen, 60)", 60)
+	test("mean(bedrooms)", 67)
+	test("mean(bedrooms, living)", 67.75)
+	test("avg(bed1, bed2)", 67)
+	test("median(bedrooms)", 67)
+	test("median(1, 2, 3, 4)", 2.5)
+	test("count(bedrooms)", 3)
+	test("clamp(kitchen, 60, 72)", 72)
+	test("abs(-2) + round(1.6)", 4)
+	test("hour()", 23)
+	test("minute()", 30)
+	test("time()", 23.5)
+	test("weekday()", 0)
+	test("mean(bedrooms) if hour() >= 22 else living", 67)
+	test("mean(bedrooms) if hour() >= 22 and hour() < 23 else living", 70)
+	test("1 if not (hour() < 7 or hour() >= 22) else 2", 2)
+	test("1 if false else 2 if true else 3", 2)
+	test("1 if living == 70 and kitchen != 70 else 0", 1)
+	test("1 if living <= 70 and kitchen > 72 else 0", 1)
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := Env{
+		Values: map[string]float64{"living": 70},
+		Lists:  map[string][]float64{"empty": {}},
+	}
+	for _, src := range []string{
+		"missing",
+		"living / 0",
+		"living % 0",
+		"mean(empty)",
+		"living if living else 1",
+		"living and true",
+		"empty + 1",
+		"living > 1",
+		"clamp(1, 5, 2)",
+	} {
+		t.Run(src, func(t *testing.T) {
+			e, err := Parse(src)
+			assert.NoError(t, err)
+			_, err = e.Eval(env)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1",
+		"1)",
+		"max(1,",
+		"nope(1)",
+		"clamp(1, 2)",
+		"hour(1)",
+		"1 if 2",
+		"living $ 2",
+		"1..2",
+		"if",
+		strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100),
+		strings.Repeat("-", 100) + "1",
+		strings.Repeat("1+", MaxLength),
+	} {
+		t.Run(src, func(t *testing.T) {
+			_, err := Parse(src)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestVars(t *testing.T) {
+	e, err := Parse("mean(bedrooms) if hour() >= 22 else max(living, kitchen, living)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bedrooms", "kitchen", "living"}, e.Vars())
+}
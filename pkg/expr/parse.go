@@ -0,0 +1,294 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("[%s]", t.text)
+}
+
+var twoCharOps = map[string]bool{"<=": true, ">=": true, "==": true, "!=": true}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			f, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number [%s] at %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text, num: f, pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		default:
+			if i+1 < len(runes) && twoCharOps[string(runes[i:i+2])] {
+				tokens = append(tokens, token{kind: tokOp, text: string(runes[i : i+2]), pos: i})
+				i += 2
+				continue
+			}
+			switch r {
+			case '+', '-', '*', '/', '%', '(', ')', ',', '<', '>':
+				tokens = append(tokens, token{kind: tokOp, text: string(r), pos: i})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected character [%c] at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the operator or keyword text
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokOp || t.kind == tokIdent) && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return fmt.Errorf("expected [%s] at %d, got %s", text, p.peek().pos, p.peek())
+	}
+	p.next()
+	return nil
+}
+
+// parseCond: or ('if' or 'else' cond)?
+func (p *parser) parseCond() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression nested too deeply")
+	}
+	then, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("if") {
+		return then, nil
+	}
+	p.next()
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect("else")
+	if err != nil {
+		return nil, err
+	}
+	els, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{then: then, cond: cond, els: els}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("or") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "or", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("and") {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "and", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.is("not") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "not", x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+var compareOps = map[string]bool{"<": true, "<=": true, ">": true, ">=": true, "==": true, "!=": true}
+
+func (p *parser) parseCompare() (node, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && compareOps[t.text] {
+		p.next()
+		r, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: t.text, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseAdd() (node, error) {
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+") || p.is("-") {
+		op := p.next().text
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseMul() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*") || p.is("/") || p.is("%") {
+		op := p.next().text
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.is("-") {
+		p.next()
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxDepth {
+			return nil, fmt.Errorf("expression nested too deeply")
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+var keywords = map[string]bool{"if": true, "else": true, "and": true, "or": true, "not": true}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &numNode{v: t.num}, nil
+	case tokIdent:
+		switch {
+		case t.text == "true" || t.text == "false":
+			return &boolNode{v: t.text == "true"}, nil
+		case keywords[t.text]:
+			return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+		case p.is("("):
+			p.next()
+			call := &callNode{name: t.text}
+			for !p.is(")") {
+				if len(call.args) > 0 {
+					err := p.expect(",")
+					if err != nil {
+						return nil, err
+					}
+				}
+				arg, err := p.parseCond()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+			}
+			p.next()
+			return call, nil
+		default:
+			return &varNode{name: t.text}, nil
+		}
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+}
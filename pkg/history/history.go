@@ -0,0 +1,128 @@
+// Package history keeps observed and sent readings in a flat append-only file
+// of JSON lines so they can be queried later
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPath is history.jsonl in the user's config directory, next to the key store
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "history.jsonl"
+	}
+	return filepath.Join(dir, "tstat-sensor-go", "history.jsonl")
+}
+
+// Writer appends observations to a history file. Several processes can append
+// to the same file, each observation is a single write.
+type Writer struct {
+	lock sync.Mutex
+	f    *os.File
+}
+
+// Create opens path for appending, creating it if needed
+func Create(path string) (*Writer, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening history: %w", err)
+	}
+	return &Writer{f: f}, nil
+}
+
+// Append writes o to the end of the file
+func (w *Writer) Append(o monitor.Observation) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("error marshalling observation: %w", err)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err = w.f.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing history: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	return w.f.Close()
+}
+
+// Query selects observations from a history file
+type Query struct {
+	Filter *monitor.Filter
+	// Since and Until limit the time range, zero means unlimited. Since is
+	// inclusive and Until exclusive.
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether o is selected by the query
+func (q *Query) Match(o monitor.Observation) bool {
+	if !q.Since.IsZero() && o.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !o.Time.Before(q.Until) {
+		return false
+	}
+	return q.Filter.Match(o)
+}
+
+// Read calls fn for each observation in the file at path that matches q, in
+// the order they were written. A missing file has no observations. Lines that
+// can't be parsed, like one cut short by a crash, are skipped with a warning.
+func Read(path string, q Query, fn func(o monitor.Observation) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening history: %w", err)
+	}
+	defer f.Close()
+	return Scan(f, q, fn)
+}
+
+// Scan is Read from r
+func Scan(r io.Reader, q Query, fn func(o monitor.Observation) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var o monitor.Observation
+		err := json.Unmarshal(scanner.Bytes(), &o)
+		if err != nil {
+			log.Warn().Err(err).Int("line", line).Msg("Skipping bad history line")
+			continue
+		}
+		if !q.Match(o) {
+			continue
+		}
+		err = fn(o)
+		if err != nil {
+			return err
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("error reading history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/monitor"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dir", "history.jsonl")
+	start := time.Date(2022, 5, 1, 22, 0, 0, 0, time.UTC)
+	var written []monitor.Observation
+	for i, name := range []string{"Living", "Bedroom", "Living", "Living"} {
+		msg, _, err := sensor.SimpleBuild(sensor.Temperature{Value: 68 + float64(i)}, name, false, sensor.MAC{}, nil, sensor.SensorType_REMOTE, i, 1)
+		assert.NoError(t, err)
+		o := monitor.Observe(msg, sensor.MemoryAddr("test"), sensor.SignatureValid, start.Add(time.Duration(i)*time.Hour))
+		written = append(written, o)
+	}
+
+	w, err := Create(path)
+	assert.NoError(t, err)
+	for _, o := range written[:2] {
+		assert.NoError(t, w.Append(o))
+	}
+	assert.NoError(t, w.Close())
+	// Reopening appends
+	w, err = Create(path)
+	assert.NoError(t, err)
+	for _, o := range written[2:] {
+		assert.NoError(t, w.Append(o))
+	}
+	assert.NoError(t, w.Close())
+
+	read := func(q Query) []monitor.Observation {
+		var got []monitor.Observation
+		assert.NoError(t, Read(path, q, func(o monitor.Observation) error {
+			got = append(got, o)
+			return nil
+		}))
+		return got
+	}
+	got := read(Query{})
+	assert.Len(t, got, 4)
+	for i := range got {
+		assert.True(t, written[i].Time.Equal(got[i].Time))
+		got[i].Time = written[i].Time
+	}
+	assert.Equal(t, written, got, "Round trips through JSON")
+
+	f := &monitor.Filter{}
+	f.AddNameGlob("living")
+	got = read(Query{Filter: f, Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)})
+	assert.Len(t, got, 1)
+	assert.Equal(t, int32(2), got[0].Seq)
+
+	assert.Empty(t, read(Query{Since: start.Add(4 * time.Hour)}))
+	assert.NoError(t, Read(filepath.Join(t.TempDir(), "missing"), Query{}, func(o monitor.Observation) error {
+		t.Fatal("Missing file has no observations")
+		return nil
+	}))
+}
@@ -0,0 +1,50 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// DefaultExecTimeout bounds how long a command can run when the input doesn't set one
+const DefaultExecTimeout = 10 * time.Second
+
+// ExecSource runs a command and parses the first line of its stdout with
+// ParseTemperature
+type ExecSource struct {
+	Command []string
+	Timeout time.Duration
+	Celsius bool
+}
+
+func (s *ExecSource) Read(ctx context.Context) (sensor.Temperature, error) {
+	if len(s.Command) == 0 {
+		return sensor.Temperature{}, errors.New("no command")
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return sensor.Temperature{}, fmt.Errorf("command timed out after %s", timeout)
+	}
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error running command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	line := strings.SplitN(strings.TrimSpace(stdout.String()), "\n", 2)[0]
+	return ParseTemperature(line, s.Celsius)
+}
@@ -0,0 +1,40 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Watcher is implemented by sources that can tell when they have a new value.
+// Pollers read them on every change as well as on their interval.
+type Watcher interface {
+	// Watch calls changed each time the source changes until ctx is done
+	Watch(ctx context.Context, changed func()) error
+}
+
+// FileSource reads a file another process rewrites and parses its first line with
+// ParseTemperature. It's watched for changes (with inotify on Linux).
+type FileSource struct {
+	Path    string
+	Celsius bool
+}
+
+func (s *FileSource) Read(ctx context.Context) (sensor.Temperature, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error reading [%s]: %w", s.Path, err)
+	}
+	line := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)[0]
+	return ParseTemperature(line, s.Celsius)
+}
+
+// Watch watches the file's directory rather than the file itself so writers that
+// replace the file with a rename are seen too
+func (s *FileSource) Watch(ctx context.Context, changed func()) error {
+	return watchFile(ctx, filepath.Clean(s.Path), changed)
+}
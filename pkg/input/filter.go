@@ -0,0 +1,199 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrRejected is wrapped by filters that throw a reading away
+var ErrRejected = errors.New("reading rejected")
+
+// Filter smooths readings or rejects bad ones before they're used. Filters are
+// stateful, each input needs its own.
+//
+// A reading only updates a filter's state once every filter in the chain has
+// accepted it, so a spike that a later filter rejects doesn't leak into an
+// earlier filter's average.
+type Filter interface {
+	// Apply returns the filtered reading or an error wrapping ErrRejected. An
+	// accepted reading doesn't change the filter's state until Commit.
+	Apply(r Reading) (Reading, error)
+	// Commit keeps the state from the last reading Apply accepted
+	Commit()
+}
+
+// FilterConfig describes a single filter in an input's config. Values are in
+// Fahrenheit unless Celsius is set, readings are converted to match.
+type FilterConfig struct {
+	// Type is ema, median, rate or bounds
+	Type string `json:"type"`
+	// Alpha is the ema smoothing factor, between 0 and 1. Smaller is smoother.
+	Alpha float64 `json:"alpha,omitempty"`
+	// Window is how many readings median uses
+	Window int `json:"window,omitempty"`
+	// MaxRate is the largest change per minute rate accepts
+	MaxRate float64 `json:"maxRate,omitempty"`
+	// MaxRejects is how many readings in a row rate rejects before accepting the
+	// new level, so a real step change isn't ignored forever. Default 3.
+	MaxRejects int `json:"maxRejects,omitempty"`
+	// Min and Max are the plausible range for bounds
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Celsius means the values above are Celsius
+	Celsius bool `json:"celsius,omitempty"`
+}
+
+// NewFilter creates the filter described by c
+func NewFilter(c FilterConfig) (Filter, error) {
+	switch c.Type {
+	case "ema":
+		if c.Alpha <= 0 || c.Alpha > 1 {
+			return nil, fmt.Errorf("ema alpha must be between 0 and 1")
+		}
+		return &EMAFilter{Alpha: c.Alpha, Celsius: c.Celsius}, nil
+	case "median":
+		if c.Window < 1 {
+			return nil, fmt.Errorf("median needs a window of at least 1")
+		}
+		return &MedianFilter{Window: c.Window, Celsius: c.Celsius}, nil
+	case "rate":
+		if c.MaxRate <= 0 {
+			return nil, fmt.Errorf("rate needs a positive maxRate")
+		}
+		if c.MaxRejects < 0 {
+			return nil, fmt.Errorf("rate maxRejects can't be negative")
+		}
+		maxRejects := c.MaxRejects
+		if maxRejects == 0 {
+			maxRejects = 3
+		}
+		return &RateFilter{MaxRate: c.MaxRate, MaxRejects: maxRejects, Celsius: c.Celsius}, nil
+	case "bounds":
+		if c.Min == nil && c.Max == nil {
+			return nil, fmt.Errorf("bounds needs min and/or max")
+		}
+		f := &BoundsFilter{Min: math.Inf(-1), Max: math.Inf(1), Celsius: c.Celsius}
+		if c.Min != nil {
+			f.Min = *c.Min
+		}
+		if c.Max != nil {
+			f.Max = *c.Max
+		}
+		if f.Min > f.Max {
+			return nil, fmt.Errorf("bounds min is above max")
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("invalid filter type [%s]", c.Type)
+	}
+}
+
+// EMAFilter is an exponential moving average
+type EMAFilter struct {
+	Alpha   float64
+	Celsius bool
+	value   float64
+	primed  bool
+	next    float64
+}
+
+func (f *EMAFilter) Apply(r Reading) (Reading, error) {
+	r.Temperature = r.Temperature.In(f.Celsius)
+	f.next = r.Temperature.Value
+	if f.primed {
+		f.next = f.value + f.Alpha*(r.Temperature.Value-f.value)
+	}
+	r.Temperature.Value = f.next
+	return r, nil
+}
+
+func (f *EMAFilter) Commit() {
+	f.value = f.next
+	f.primed = true
+}
+
+// MedianFilter is the median of the last Window readings, it removes single
+// sample spikes without lagging as much as an average
+type MedianFilter struct {
+	Window  int
+	Celsius bool
+	values  []float64
+	next    []float64
+}
+
+func (f *MedianFilter) Apply(r Reading) (Reading, error) {
+	r.Temperature = r.Temperature.In(f.Celsius)
+	f.next = append(append([]float64(nil), f.values...), r.Temperature.Value)
+	if len(f.next) > f.Window {
+		f.next = f.next[len(f.next)-f.Window:]
+	}
+	sorted := append([]float64(nil), f.next...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		r.Temperature.Value = (sorted[mid-1] + sorted[mid]) / 2
+	} else {
+		r.Temperature.Value = sorted[mid]
+	}
+	return r, nil
+}
+
+func (f *MedianFilter) Commit() {
+	f.values = f.next
+}
+
+// RateFilter rejects readings that change faster than MaxRate degrees per minute
+// from the last accepted reading. After MaxRejects rejections in a row the new
+// level is accepted. Its own rejections count immediately, a reading another
+// filter rejects doesn't count either way.
+type RateFilter struct {
+	MaxRate    float64
+	MaxRejects int
+	Celsius    bool
+	last       Reading
+	primed     bool
+	rejects    int
+	next       Reading
+}
+
+func (f *RateFilter) Apply(r Reading) (Reading, error) {
+	r.Temperature = r.Temperature.In(f.Celsius)
+	if f.primed {
+		change := math.Abs(r.Temperature.Value - f.last.Temperature.Value)
+		// Never divide by less than a second so back to back reads aren't all rejected
+		minutes := math.Max(r.Time.Sub(f.last.Time).Minutes(), 1.0/60)
+		rate := change / minutes
+		if rate > f.MaxRate && f.rejects < f.MaxRejects {
+			f.rejects++
+			return r, fmt.Errorf("%w: changed %.2f in %.2f minutes", ErrRejected, change, minutes)
+		}
+	}
+	f.next = r
+	return r, nil
+}
+
+func (f *RateFilter) Commit() {
+	f.last = f.next
+	f.primed = true
+	f.rejects = 0
+}
+
+// BoundsFilter rejects readings outside Min and Max, like the 85C a DS18B20
+// reports when it resets
+type BoundsFilter struct {
+	Min     float64
+	Max     float64
+	Celsius bool
+}
+
+func (f *BoundsFilter) Apply(r Reading) (Reading, error) {
+	t := r.Temperature.In(f.Celsius)
+	if math.IsNaN(t.Value) || t.Value < f.Min || t.Value > f.Max {
+		return r, fmt.Errorf("%w: %.2f outside %.2f to %.2f", ErrRejected, t.Value, f.Min, f.Max)
+	}
+	return r, nil
+}
+
+func (f *BoundsFilter) Commit() {}
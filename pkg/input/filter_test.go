@@ -0,0 +1,166 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/calibration"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func reading(f float64, minutes int) Reading {
+	start := time.Date(2022, 5, 2, 12, 0, 0, 0, time.UTC)
+	return Reading{Temperature: sensor.Temperature{Value: f}, Time: start.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func apply(t *testing.T, f Filter, r Reading) (float64, error) {
+	out, err := f.Apply(r)
+	if err == nil {
+		f.Commit()
+	}
+	return out.Temperature.Value, err
+}
+
+func TestFilters(t *testing.T) {
+	t.Run("ema", func(t *testing.T) {
+		f, err := NewFilter(FilterConfig{Type: "ema", Alpha: 0.5})
+		assert.NoError(t, err)
+		v, _ := apply(t, f, reading(70, 0))
+		assert.Equal(t, 70.0, v)
+		v, _ = apply(t, f, reading(72, 1))
+		assert.Equal(t, 71.0, v)
+		v, _ = apply(t, f, reading(72, 2))
+		assert.Equal(t, 71.5, v)
+	})
+	t.Run("median", func(t *testing.T) {
+		f, err := NewFilter(FilterConfig{Type: "median", Window: 3})
+		assert.NoError(t, err)
+		for i, c := range []struct{ in, out float64 }{{70, 70}, {71, 70.5}, {185, 71}, {72, 72}, {73, 73}} {
+			v, err := apply(t, f, reading(c.in, i))
+			assert.NoError(t, err)
+			assert.Equal(t, c.out, v, "reading %d", i)
+		}
+	})
+	t.Run("rate", func(t *testing.T) {
+		f, err := NewFilter(FilterConfig{Type: "rate", MaxRate: 2, MaxRejects: 2})
+		assert.NoError(t, err)
+		_, err = apply(t, f, reading(70, 0))
+		assert.NoError(t, err)
+		_, err = apply(t, f, reading(71, 1))
+		assert.NoError(t, err)
+		_, err = apply(t, f, reading(80, 2))
+		assert.True(t, errors.Is(err, ErrRejected))
+		_, err = apply(t, f, reading(72, 3))
+		assert.NoError(t, err, "Rate is measured from the last accepted reading")
+		_, err = apply(t, f, reading(90, 4))
+		assert.Error(t, err)
+		_, err = apply(t, f, reading(90, 5))
+		assert.Error(t, err)
+		_, err = apply(t, f, reading(90, 6))
+		assert.NoError(t, err, "A persistent step is accepted after MaxRejects")
+	})
+	t.Run("bounds", func(t *testing.T) {
+		max := 50.0
+		f, err := NewFilter(FilterConfig{Type: "bounds", Max: &max, Celsius: true})
+		assert.NoError(t, err)
+		_, err = apply(t, f, Reading{Temperature: sensor.Temperature{Value: 85, Celsius: true}})
+		assert.True(t, errors.Is(err, ErrRejected))
+		v, err := apply(t, f, Reading{Temperature: sensor.Temperature{Value: 72}})
+		assert.NoError(t, err)
+		assert.Equal(t, 72.0, v, "Bounds passes the reading through unconverted")
+	})
+	t.Run("units", func(t *testing.T) {
+		f, err := NewFilter(FilterConfig{Type: "ema", Alpha: 1})
+		assert.NoError(t, err)
+		out, _ := f.Apply(Reading{Temperature: sensor.Temperature{Value: 20, Celsius: true}})
+		assert.False(t, out.Temperature.Celsius)
+		assert.InDelta(t, 68.0, out.Temperature.Value, 0.001)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, c := range []FilterConfig{
+			{Type: "ema"},
+			{Type: "ema", Alpha: 2},
+			{Type: "median"},
+			{Type: "rate"},
+			{Type: "rate", MaxRate: 2, MaxRejects: -1},
+			{Type: "bounds"},
+			{Type: "kalman"},
+		} {
+			_, err := NewFilter(c)
+			assert.Error(t, err, c.Type)
+		}
+	})
+}
+
+func TestPollerRejects(t *testing.T) {
+	min := 32.0
+	c := Config{Type: "exec", Command: []string{"echo", "-40"}, Filters: []FilterConfig{{Type: "bounds", Min: &min}}}
+	p, err := c.NewPoller("outside")
+	assert.NoError(t, err)
+	assert.Error(t, p.Poll(context.Background()))
+	_, ok := p.Latest()
+	assert.False(t, ok)
+	stats := p.Stats()
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, uint64(0), stats.Errors)
+}
+
+func TestPollerFilterChain(t *testing.T) {
+	source := &stepSource{}
+	max := 90.0
+	p := NewPoller("chain", source, time.Second)
+	for _, c := range []FilterConfig{{Type: "ema", Alpha: 0.5}, {Type: "median", Window: 3}, {Type: "bounds", Max: &max}} {
+		f, err := NewFilter(c)
+		assert.NoError(t, err)
+		p.Filters = append(p.Filters, f)
+	}
+	source.values = []float64{70, 185, 72}
+	assert.NoError(t, p.Poll(context.Background()))
+	assert.Error(t, p.Poll(context.Background()), "The smoothed spike is still out of bounds")
+	assert.NoError(t, p.Poll(context.Background()))
+	r, _ := p.Latest()
+	assert.Equal(t, 70.5, r.Temperature.Value, "The rejected spike isn't in the earlier filters' state")
+}
+
+// stepSource returns each of values in turn
+type stepSource struct {
+	values []float64
+}
+
+func (s *stepSource) Read(ctx context.Context) (sensor.Temperature, error) {
+	v := s.values[0]
+	s.values = s.values[1:]
+	return sensor.Temperature{Value: v}, nil
+}
+
+func TestPollerCalibration(t *testing.T) {
+	min := 60.0
+	c := Config{
+		Type:        "exec",
+		Command:     []string{"echo", "59"},
+		Calibration: &calibration.Calibration{Offset: 2},
+		Filters:     []FilterConfig{{Type: "bounds", Min: &min}},
+	}
+	p, err := c.NewPoller("probe")
+	assert.NoError(t, err)
+	assert.NoError(t, p.Poll(context.Background()), "Filters see the calibrated value")
+	r, _ := p.Latest()
+	assert.Equal(t, 61.0, r.Temperature.Value)
+}
+
+func TestSubscribe(t *testing.T) {
+	p, err := Config{Type: "exec", Command: []string{"echo", "70"}}.NewPoller("p")
+	assert.NoError(t, err)
+	c := p.Subscribe()
+	assert.NoError(t, p.Poll(context.Background()))
+	assert.NoError(t, p.Poll(context.Background()))
+	<-c
+	select {
+	case <-c:
+		t.Fatal("Signals aren't queued")
+	default:
+	}
+}
@@ -0,0 +1,139 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// DefaultHTTPTimeout bounds each request when the input doesn't set a timeout
+const DefaultHTTPTimeout = 10 * time.Second
+
+// HTTPSource fetches a JSON document and extracts a temperature from it with a
+// JSON path like $.sensors[0].temp. The value can be a number or a string
+// ParseTemperature accepts.
+type HTTPSource struct {
+	URL     string
+	Query   string
+	Headers map[string]string
+	Timeout time.Duration
+	Celsius bool
+	Client  *http.Client
+}
+
+func (s *HTTPSource) Read(ctx context.Context) (sensor.Temperature, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error fetching [%s]: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return sensor.Temperature{}, fmt.Errorf("error fetching [%s]: %s", s.URL, resp.Status)
+	}
+	var doc interface{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error decoding [%s]: %w", s.URL, err)
+	}
+	v, err := JSONPath(doc, s.Query)
+	if err != nil {
+		return sensor.Temperature{}, err
+	}
+	switch t := v.(type) {
+	case float64:
+		return sensor.Temperature{Value: t, Celsius: s.Celsius}, nil
+	case string:
+		return ParseTemperature(t, s.Celsius)
+	default:
+		return sensor.Temperature{}, fmt.Errorf("value at [%s] is not a number", s.Query)
+	}
+}
+
+// JSONPath extracts a value from a decoded JSON document. Paths are a subset of
+// JSONPath: an optional leading $, .key or ["key"] for objects and [n] for arrays,
+// e.g. $.sensors[0].temp or data["living room"].value
+func JSONPath(doc interface{}, path string) (interface{}, error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	v := doc
+	for p != "" {
+		var key string
+		index := -1
+		switch {
+		case strings.HasPrefix(p, "["):
+			end := strings.Index(p, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path [%s]: unclosed [", path)
+			}
+			inner := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+			if unquoted, err := strconv.Unquote(inner); err == nil {
+				key = unquoted
+			} else if strings.HasPrefix(inner, "'") && strings.HasSuffix(inner, "'") && len(inner) > 1 {
+				key = inner[1 : len(inner)-1]
+			} else {
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("invalid path [%s]: bad index [%s]", path, inner)
+				}
+				index = i
+			}
+		default:
+			p = strings.TrimPrefix(p, ".")
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			key = p[:end]
+			p = p[end:]
+			if key == "" {
+				return nil, fmt.Errorf("invalid path [%s]: empty key", path)
+			}
+		}
+		if index >= 0 {
+			a, ok := v.([]interface{})
+			if !ok || index >= len(a) {
+				return nil, fmt.Errorf("path [%s]: no index %d", path, index)
+			}
+			v = a[index]
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("path [%s]: no key [%s]", path, key)
+		}
+		v, ok = m[key]
+		if !ok {
+			return nil, fmt.Errorf("path [%s]: no key [%s]", path, key)
+		}
+	}
+	if v == nil {
+		return nil, errors.New("value is null")
+	}
+	return v, nil
+}
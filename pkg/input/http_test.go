@@ -0,0 +1,86 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"sensors": [{"temp": 21.5}, {"temp": "70F"}], "living room": {"value": 20}}`), &doc))
+	test := func(path string, expected interface{}) {
+		t.Run(path, func(t *testing.T) {
+			v, err := JSONPath(doc, path)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, v)
+		})
+	}
+	test("$.sensors[0].temp", 21.5)
+	test("sensors[1].temp", "70F")
+	test(`$["living room"].value`, 20.0)
+	test(`$['living room']['value']`, 20.0)
+
+	for _, bad := range []string{"$.nope", "$.sensors[2].temp", "$.sensors.temp", "$.sensors[x]", "$.sensors[0", "$..temp"} {
+		_, err := JSONPath(doc, bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	var failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"data": {"temp": 21.5}}`)
+	}))
+	defer server.Close()
+
+	p, err := Config{
+		Type:     "http",
+		URL:      server.URL,
+		Query:    "$.data.temp",
+		Headers:  map[string]string{"Authorization": "secret"},
+		Celsius:  true,
+		Interval: Duration(time.Second),
+		MaxAge:   Duration(time.Hour),
+	}.NewPoller("http")
+	assert.NoError(t, err)
+	assert.True(t, p.Stale(), "Stale until the first reading")
+	assert.NoError(t, p.Poll(context.Background()))
+	r, ok := p.Latest()
+	assert.True(t, ok)
+	assert.Equal(t, sensor.Temperature{Value: 21.5, Celsius: true}, r.Temperature)
+	assert.False(t, p.Stale())
+	assert.Equal(t, time.Second, p.NextDelay())
+
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 3; i++ {
+		assert.Error(t, p.Poll(context.Background()))
+	}
+	assert.Equal(t, 8*time.Second, p.NextDelay(), "Backs off while failing")
+	assert.Equal(t, 3, p.Stats().ConsecutiveErrors)
+	p.MaxBackoff = 5 * time.Second
+	assert.Equal(t, 5*time.Second, p.NextDelay(), "Backoff is capped")
+
+	p.MaxAge = time.Nanosecond
+	assert.True(t, p.Stale(), "Old reading goes stale")
+	r, ok = p.Latest()
+	assert.True(t, ok, "Last good reading is kept")
+	assert.Equal(t, 21.5, r.Temperature.Value)
+
+	atomic.StoreInt32(&failing, 0)
+	assert.NoError(t, p.Poll(context.Background()))
+	assert.Equal(t, time.Second, p.NextDelay(), "Success resets the backoff")
+}
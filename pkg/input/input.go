@@ -0,0 +1,157 @@
+// Package input reads temperatures from local sources so they can be sent as a
+// simulated sensor.
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/calibration"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Source produces a temperature reading each time it's read
+type Source interface {
+	Read(ctx context.Context) (sensor.Temperature, error)
+}
+
+// Config describes a single input in a simulator config file
+type Config struct {
+	// Type is the kind of source (w1, hwmon, exec, file, http)
+	Type string `json:"type"`
+	// Path is the file to read for file based sources
+	Path string `json:"path,omitempty"`
+	// Command is the program and arguments for exec sources
+	Command []string `json:"command,omitempty"`
+	// URL to fetch for http sources
+	URL string `json:"url,omitempty"`
+	// Query is the JSON path of the value in http responses
+	Query string `json:"query,omitempty"`
+	// Headers are added to http requests
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout bounds each run of an exec source or http request
+	Timeout Duration `json:"timeout,omitempty"`
+	// Celsius means plain numbers from exec, file and http sources are Celsius
+	// rather than Fahrenheit
+	Celsius bool `json:"celsius,omitempty"`
+	// Interval is how often to poll the source
+	Interval Duration `json:"interval,omitempty"`
+	// MaxBackoff caps how far polling slows down while the source is failing
+	MaxBackoff Duration `json:"maxBackoff,omitempty"`
+	// MaxAge is how old the last good reading can get before it's stale, zero
+	// means never
+	MaxAge Duration `json:"maxAge,omitempty"`
+	// Calibration corrects every reading before the filters
+	Calibration *calibration.Calibration `json:"calibration,omitempty"`
+	// Filters are applied to every reading in order
+	Filters []FilterConfig `json:"filters,omitempty"`
+}
+
+// DefaultInterval is used when an input doesn't set one
+const DefaultInterval = 30 * time.Second
+
+// Validate checks that none of the durations are negative, the source is
+// checked by NewSource
+func (c Config) Validate() error {
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{{"interval", c.Interval}, {"timeout", c.Timeout}, {"maxBackoff", c.MaxBackoff}, {"maxAge", c.MaxAge}} {
+		if d.value < 0 {
+			return fmt.Errorf("%s can't be negative", d.name)
+		}
+	}
+	return nil
+}
+
+// NewPoller creates the source described by c and a poller for it
+func (c Config) NewPoller(name string) (*Poller, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	src, err := NewSource(c)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPoller(name, src, c.Interval.Or(DefaultInterval))
+	p.MaxBackoff = time.Duration(c.MaxBackoff)
+	p.MaxAge = time.Duration(c.MaxAge)
+	if c.Calibration != nil {
+		if err := c.Calibration.Validate(); err != nil {
+			return nil, fmt.Errorf("calibration: %w", err)
+		}
+		p.Calibration = c.Calibration
+	}
+	for i, fc := range c.Filters {
+		f, err := NewFilter(fc)
+		if err != nil {
+			return nil, fmt.Errorf("filter %d: %w", i+1, err)
+		}
+		p.Filters = append(p.Filters, f)
+	}
+	return p, nil
+}
+
+// NewSource creates the source described by c
+func NewSource(c Config) (Source, error) {
+	switch c.Type {
+	case "w1":
+		if c.Path == "" {
+			return nil, fmt.Errorf("w1 input needs a path")
+		}
+		return &W1Source{Path: c.Path}, nil
+	case "hwmon":
+		if c.Path == "" {
+			return nil, fmt.Errorf("hwmon input needs a path")
+		}
+		return &HwmonSource{Path: c.Path}, nil
+	case "exec":
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("exec input needs a command")
+		}
+		return &ExecSource{Command: c.Command, Timeout: time.Duration(c.Timeout), Celsius: c.Celsius}, nil
+	case "file":
+		if c.Path == "" {
+			return nil, fmt.Errorf("file input needs a path")
+		}
+		return &FileSource{Path: c.Path, Celsius: c.Celsius}, nil
+	case "http":
+		if c.URL == "" || c.Query == "" {
+			return nil, fmt.Errorf("http input needs a url and query")
+		}
+		return &HTTPSource{URL: c.URL, Query: c.Query, Headers: c.Headers, Timeout: time.Duration(c.Timeout), Celsius: c.Celsius}, nil
+	default:
+		return nil, fmt.Errorf("invalid input type [%s]", c.Type)
+	}
+}
+
+// Duration is a time.Duration that's written as a string ("30s", "5m") in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Or returns d as a time.Duration, or def if d is zero
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
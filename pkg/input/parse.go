@@ -0,0 +1,31 @@
+package input
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// ParseTemperature parses a plain number ("21.5") or a number with a unit suffix
+// ("21.5C", "70F", "21.5 °C"). Plain numbers are Celsius if celsius is set,
+// otherwise Fahrenheit.
+func ParseTemperature(s string, celsius bool) (sensor.Temperature, error) {
+	v := strings.TrimSpace(s)
+	upper := strings.ToUpper(v)
+	switch {
+	case strings.HasSuffix(upper, "C"):
+		celsius = true
+		v = v[:len(v)-1]
+	case strings.HasSuffix(upper, "F"):
+		celsius = false
+		v = v[:len(v)-1]
+	}
+	v = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "°"))
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("invalid temperature [%s]", s)
+	}
+	return sensor.Temperature{Value: f, Celsius: celsius}, nil
+}
@@ -0,0 +1,213 @@
+package input
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/calibration"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultMaxBackoff caps the polling backoff when the input doesn't set one
+const DefaultMaxBackoff = 5 * time.Minute
+
+// Reading is a temperature and when it was read
+type Reading struct {
+	Temperature sensor.Temperature
+	Time        time.Time
+}
+
+// PollerStats are running totals for a Poller
+type PollerStats struct {
+	Reads     uint64
+	Errors    uint64
+	LastError error
+	// ConsecutiveErrors is how many polls in a row have failed
+	ConsecutiveErrors int
+	// Rejected is how many readings a filter threw away
+	Rejected uint64
+}
+
+// Poller reads a Source on an interval and keeps the latest good reading. While
+// the source is failing the interval doubles after each error up to MaxBackoff.
+type Poller struct {
+	Name     string
+	Source   Source
+	Interval time.Duration
+	// MaxBackoff caps the delay between polls while failing, zero means
+	// DefaultMaxBackoff (or Interval if that's longer)
+	MaxBackoff time.Duration
+	// MaxAge is how old the latest reading can be before Stale reports it, zero
+	// means readings never go stale
+	MaxAge time.Duration
+	// Calibration corrects each reading before the filters
+	Calibration *calibration.Calibration
+	// Filters are applied in order to each reading before it's kept
+	Filters []Filter
+
+	lock   sync.Mutex
+	latest Reading
+	ok     bool
+	stale  bool
+	stats  PollerStats
+	ready  chan struct{}
+	subs   []chan struct{}
+}
+
+func NewPoller(name string, source Source, interval time.Duration) *Poller {
+	return &Poller{Name: name, Source: source, Interval: interval, ready: make(chan struct{})}
+}
+
+// Run polls immediately and then every Interval until ctx is done. Sources that
+// implement Watcher are also polled whenever they change.
+func (p *Poller) Run(ctx context.Context) {
+	changes := make(chan struct{}, 1)
+	if w, ok := p.Source.(Watcher); ok {
+		go func() {
+			err := w.Watch(ctx, func() {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				log.Warn().Err(err).Str("input", p.Name).Msg("Error watching input, falling back to polling")
+			}
+		}()
+	}
+	for {
+		_ = p.Poll(ctx)
+		timer := time.NewTimer(p.NextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-changes:
+			timer.Stop()
+		}
+	}
+}
+
+// NextDelay is how long to wait before the next poll, backing off exponentially
+// after consecutive errors
+func (p *Poller) NextDelay() time.Duration {
+	p.lock.Lock()
+	failures := p.stats.ConsecutiveErrors
+	p.lock.Unlock()
+	max := p.MaxBackoff
+	if max == 0 {
+		max = DefaultMaxBackoff
+	}
+	if max < p.Interval {
+		max = p.Interval
+	}
+	delay := p.Interval
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// Poll reads the source once, keeping the reading if it succeeds and passes the
+// filters
+func (p *Poller) Poll(ctx context.Context) error {
+	temp, err := p.Source.Read(ctx)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err != nil {
+		p.stats.Errors++
+		p.stats.ConsecutiveErrors++
+		p.stats.LastError = err
+		log.Warn().Err(err).Str("input", p.Name).Int("consecutiveErrors", p.stats.ConsecutiveErrors).Msg("Error reading input")
+		p.checkStaleLocked(time.Now())
+		return err
+	}
+	p.stats.Reads++
+	p.stats.ConsecutiveErrors = 0
+	reading := Reading{Temperature: temp, Time: time.Now()}
+	if p.Calibration != nil {
+		reading.Temperature = p.Calibration.Apply(reading.Temperature)
+	}
+	for _, f := range p.Filters {
+		reading, err = f.Apply(reading)
+		if err != nil {
+			p.stats.Rejected++
+			log.Warn().Err(err).Str("input", p.Name).Float64("value", temp.Value).Bool("celsius", temp.Celsius).Uint64("rejected", p.stats.Rejected).Msg("Rejected reading")
+			p.checkStaleLocked(time.Now())
+			return err
+		}
+	}
+	for _, f := range p.Filters {
+		f.Commit()
+	}
+	p.latest = reading
+	if !p.ok {
+		close(p.ready)
+	}
+	p.ok = true
+	for _, c := range p.subs {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	p.checkStaleLocked(p.latest.Time)
+	log.Debug().Str("input", p.Name).Float64("value", temp.Value).Bool("celsius", temp.Celsius).Msg("Read input")
+	return nil
+}
+
+// Latest returns the most recent good reading, ok is false if there hasn't been one
+func (p *Poller) Latest() (Reading, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.latest, p.ok
+}
+
+// Stale reports whether the latest reading is older than MaxAge, or there hasn't
+// been one yet
+func (p *Poller) Stale() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.checkStaleLocked(time.Now())
+}
+
+// checkStaleLocked updates and logs the stale state as of now
+func (p *Poller) checkStaleLocked(now time.Time) bool {
+	stale := !p.ok || (p.MaxAge > 0 && now.Sub(p.latest.Time) > p.MaxAge)
+	if stale != p.stale && p.ok {
+		if stale {
+			log.Warn().Str("input", p.Name).Time("lastReading", p.latest.Time).Msg("Input is stale")
+		} else {
+			log.Info().Str("input", p.Name).Msg("Input is fresh again")
+		}
+	}
+	p.stale = stale
+	return stale
+}
+
+// Subscribe returns a channel that's signalled after each good reading. Signals
+// aren't queued, a slow reader only sees that there's been at least one.
+func (p *Poller) Subscribe() <-chan struct{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	c := make(chan struct{}, 1)
+	p.subs = append(p.subs, c)
+	return c
+}
+
+// Ready is closed once the first good reading is in
+func (p *Poller) Ready() <-chan struct{} {
+	return p.ready
+}
+
+func (p *Poller) Stats() PollerStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
@@ -0,0 +1,81 @@
+package input
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemperature(t *testing.T) {
+	test := func(s string, celsius bool, expected sensor.Temperature) {
+		t.Run(s, func(t *testing.T) {
+			temp, err := ParseTemperature(s, celsius)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, temp)
+		})
+	}
+	test("21.5", false, sensor.Temperature{Value: 21.5})
+	test("21.5", true, sensor.Temperature{Value: 21.5, Celsius: true})
+	test("21.5C", false, sensor.Temperature{Value: 21.5, Celsius: true})
+	test(" 21.5 c\n", false, sensor.Temperature{Value: 21.5, Celsius: true})
+	test("70F", true, sensor.Temperature{Value: 70})
+	test("-3.5°C", false, sensor.Temperature{Value: -3.5, Celsius: true})
+	test("70 °F", true, sensor.Temperature{Value: 70})
+
+	for _, bad := range []string{"", "C", "warm", "21.5K"} {
+		_, err := ParseTemperature(bad, false)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestExecSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	src, err := NewSource(Config{Type: "exec", Command: []string{"sh", "-c", "echo 21.5C; echo ignored"}})
+	assert.NoError(t, err)
+	temp, err := src.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, sensor.Temperature{Value: 21.5, Celsius: true}, temp)
+
+	src = &ExecSource{Command: []string{"sh", "-c", "echo oops >&2; exit 1"}}
+	_, err = src.Read(context.Background())
+	assert.Error(t, err)
+
+	src = &ExecSource{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond}
+	_, err = src.Read(context.Background())
+	assert.Error(t, err, "Times out")
+}
+
+func TestFileSourceWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "temp")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("70\n"), 0644))
+
+	p := NewPoller("file", &FileSource{Path: path}, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	<-p.Ready()
+	r, _ := p.Latest()
+	assert.Equal(t, 70.0, r.Temperature.Value)
+
+	// Replace the file the way most writers do, repeatedly in case the watch
+	// isn't set up yet
+	tmp := filepath.Join(dir, "temp.tmp")
+	assert.Eventually(t, func() bool {
+		if r, _ := p.Latest(); r.Temperature.Value == 71.5 {
+			return true
+		}
+		assert.NoError(t, ioutil.WriteFile(tmp, []byte("71.5F\n"), 0644))
+		assert.NoError(t, os.Rename(tmp, path))
+		return false
+	}, 5*time.Second, 50*time.Millisecond, "Change is picked up without waiting for the interval")
+}
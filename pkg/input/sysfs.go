@@ -0,0 +1,77 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// ErrCRC is returned when a 1-Wire read fails its CRC check, the next read
+// usually succeeds
+var ErrCRC = errors.New("1-wire crc check failed")
+
+// W1Source reads a DS18B20 style w1_slave file, usually
+// /sys/bus/w1/devices/28-*/w1_slave
+type W1Source struct {
+	Path string
+}
+
+func (s *W1Source) Read(ctx context.Context) (sensor.Temperature, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error reading [%s]: %w", s.Path, err)
+	}
+	c, err := ParseW1Slave(string(data))
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error parsing [%s]: %w", s.Path, err)
+	}
+	return sensor.Temperature{Value: c, Celsius: true}, nil
+}
+
+// ParseW1Slave parses the contents of a w1_slave file into degrees Celsius.
+// The file looks like:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func ParseW1Slave(data string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("expected 2 lines, got %d", len(lines))
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, ErrCRC
+	}
+	i := strings.LastIndex(lines[1], "t=")
+	if i < 0 {
+		return 0, errors.New("no temperature found")
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(lines[1][i+2:]))
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature: %w", err)
+	}
+	return float64(milli) / 1000, nil
+}
+
+// HwmonSource reads a hwmon temp*_input file, usually
+// /sys/class/hwmon/hwmon*/temp1_input
+type HwmonSource struct {
+	Path string
+}
+
+func (s *HwmonSource) Read(ctx context.Context) (sensor.Temperature, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error reading [%s]: %w", s.Path, err)
+	}
+	// Millidegrees Celsius
+	milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return sensor.Temperature{}, fmt.Errorf("error parsing [%s]: %w", s.Path, err)
+	}
+	return sensor.Temperature{Value: float64(milli) / 1000, Celsius: true}, nil
+}
@@ -0,0 +1,69 @@
+package input
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseW1Slave(t *testing.T) {
+	c, err := ParseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 23.125, c)
+
+	c, err = ParseW1Slave("5e ff 4b 46 7f ff 0c 10 1c : crc=1c YES\n5e ff 4b 46 7f ff 0c 10 1c t=-10125\n")
+	assert.NoError(t, err)
+	assert.Equal(t, -10.125, c, "Negative temps")
+
+	_, err = ParseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=00 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	assert.ErrorIs(t, err, ErrCRC)
+
+	_, err = ParseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n")
+	assert.Error(t, err, "Truncated")
+}
+
+func TestSysfsSources(t *testing.T) {
+	// Fake sysfs tree
+	root := t.TempDir()
+	w1 := filepath.Join(root, "bus", "w1", "devices", "28-0000075565e3", "w1_slave")
+	hwmon := filepath.Join(root, "class", "hwmon", "hwmon0", "temp1_input")
+	write := func(path string, data string) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Dir(w1), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(hwmon), 0755))
+	write(w1, "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	write(hwmon, "45000\n")
+
+	src, err := NewSource(Config{Type: "w1", Path: w1})
+	assert.NoError(t, err)
+	temp, err := src.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 23.125, temp.Value)
+	assert.True(t, temp.Celsius)
+
+	src, err = NewSource(Config{Type: "hwmon", Path: hwmon})
+	assert.NoError(t, err)
+	temp, err = src.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 45.0, temp.Value)
+
+	// A bad CRC is an error and the poller keeps the last good value
+	p := NewPoller("probe", &W1Source{Path: w1}, DefaultInterval)
+	assert.NoError(t, p.Poll(context.Background()))
+	write(w1, "72 01 4b 46 7f ff 0e 10 57 : crc=00 NO\n72 01 4b 46 7f ff 0e 10 57 t=99000\n")
+	assert.ErrorIs(t, p.Poll(context.Background()), ErrCRC)
+	r, ok := p.Latest()
+	assert.True(t, ok)
+	assert.Equal(t, 23.125, r.Temperature.Value)
+	assert.Equal(t, uint64(1), p.Stats().Errors)
+
+	_, err = NewSource(Config{Type: "w1"})
+	assert.Error(t, err, "Path required")
+	_, err = NewSource(Config{Type: "nope"})
+	assert.Error(t, err)
+}
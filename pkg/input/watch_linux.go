@@ -0,0 +1,49 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+func watchFile(ctx context.Context, path string, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("error initializing inotify: %w", err)
+	}
+	// Non-blocking so reads go through the runtime poller and Close unblocks them
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+	_, err = syscall.InotifyAddWatch(fd, filepath.Dir(path), syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO)
+	if err != nil {
+		return fmt.Errorf("error watching [%s]: %w", filepath.Dir(path), err)
+	}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	name := filepath.Base(path)
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error reading inotify events: %w", err)
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			if strings.TrimRight(string(nameBytes), "\x00") == name {
+				changed()
+			}
+		}
+	}
+}
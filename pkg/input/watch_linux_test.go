@@ -0,0 +1,54 @@
+package input
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchWaitsForWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "temp")
+	changes := make(chan struct{}, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchFile(ctx, path, func() { changes <- struct{}{} })
+
+	// Wait for the watch to be set up
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, ioutil.WriteFile(path, []byte("70\n"), 0644))
+		select {
+		case <-changes:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, os.Remove(path))
+	time.Sleep(50 * time.Millisecond)
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	// A writer that hasn't finished isn't read yet
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	f.WriteString("7")
+	select {
+	case <-changes:
+		t.Error("Changed before the writer closed the file")
+	case <-time.After(100 * time.Millisecond):
+	}
+	f.WriteString("1\n")
+	assert.NoError(t, f.Close())
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Error("Not changed after the writer closed the file")
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package input
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// watchFile polls the modification time where inotify isn't available
+func watchFile(ctx context.Context, path string, changed func()) error {
+	var last time.Time
+	if info, err := os.Stat(path); err == nil {
+		last = info.ModTime()
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime() != last {
+			last = info.ModTime()
+			changed()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package metrics writes metrics in the Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ContentType is the Content-Type of the text exposition format
+const ContentType = "text/plain; version=0.0.4"
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// Label is a sample's label, its value is escaped when it's written
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a metric's value for one set of labels
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Write writes a metric's HELP and TYPE lines followed by its samples. kind is
+// counter or gauge.
+func Write(w io.Writer, name string, kind string, help string, samples []Sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, kind)
+	for _, s := range samples {
+		var b strings.Builder
+		b.WriteString(name)
+		if len(s.Labels) > 0 {
+			b.WriteByte('{')
+			for i, l := range s.Labels {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString(l.Name + `="` + labelEscaper.Replace(l.Value) + `"`)
+			}
+			b.WriteByte('}')
+		}
+		fmt.Fprintf(w, "%s %v\n", b.String(), s.Value)
+	}
+}
+
+// Bool is 1 for true and 0 for false
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
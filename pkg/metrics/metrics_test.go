@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	Write(&b, "tstat_test", "gauge", "A test\nmetric", []Sample{
+		{Labels: []Label{{"name", `Living "Room"`}, {"path", `C:\temp`}}, Value: 1.5},
+		{Labels: []Label{{"name", "two\nlines\tand a tab"}}, Value: math.Inf(1)},
+		{Value: 3},
+	})
+	assert.Equal(t, `# HELP tstat_test A test\nmetric
+# TYPE tstat_test gauge
+tstat_test{name="Living \"Room\"",path="C:\\temp"} 1.5
+tstat_test{name="two\nlines	and a tab"} +Inf
+tstat_test 3
+`, b.String())
+}
@@ -0,0 +1,137 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertKind is what an alert is about
+type AlertKind string
+
+const (
+	// AlertSilent is a sensor that hasn't been heard from
+	AlertSilent AlertKind = "silent"
+	// AlertBattery is a sensor with a low battery, or one estimated to run out soon
+	AlertBattery AlertKind = "battery"
+)
+
+// AlertConfig sets when alerts are raised, zero values disable each check
+type AlertConfig struct {
+	// Silent alerts when a sensor hasn't been heard from for this long
+	Silent time.Duration
+	// Battery alerts when a sensor's battery is at or below this percentage
+	Battery int32
+	// BatteryWithin alerts when a sensor's battery is estimated to run out within this long
+	BatteryWithin time.Duration
+}
+
+// Alert is a raised or cleared alert for a sensor
+type Alert struct {
+	Kind AlertKind
+	MAC  string
+	Name string
+	// Time is when the alert was raised
+	Time    time.Time
+	Message string
+	// Cleared is set when the condition has gone away
+	Cleared bool
+}
+
+func (a Alert) String() string {
+	if a.Cleared {
+		return fmt.Sprintf("%s (%s): %s alert cleared", a.Name, a.MAC, a.Kind)
+	}
+	return fmt.Sprintf("%s (%s): %s", a.Name, a.MAC, a.Message)
+}
+
+type alertKey struct {
+	mac  string
+	kind AlertKind
+}
+
+// Alerter checks tracked sensors against an AlertConfig and remembers which
+// alerts are active so each is only reported when it's raised and cleared
+type Alerter struct {
+	Config AlertConfig
+
+	lock   sync.Mutex
+	active map[alertKey]Alert
+}
+
+func NewAlerter(c AlertConfig) *Alerter {
+	return &Alerter{Config: c, active: make(map[alertKey]Alert)}
+}
+
+// Check evaluates every entry at now, returning alerts that were raised or
+// cleared since the last check
+func (a *Alerter) Check(entries []Entry, now time.Time) []Alert {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var changed []Alert
+	for _, e := range entries {
+		for _, kind := range []AlertKind{AlertSilent, AlertBattery} {
+			key := alertKey{e.Last.MAC, kind}
+			message, raised := a.check(kind, e, now)
+			prev, active := a.active[key]
+			switch {
+			case raised && !active:
+				alert := Alert{Kind: kind, MAC: e.Last.MAC, Name: e.Last.Name, Time: now, Message: message}
+				a.active[key] = alert
+				changed = append(changed, alert)
+			case raised:
+				// Keep the message current without reporting it again
+				prev.Message = message
+				a.active[key] = prev
+			case active:
+				delete(a.active, key)
+				prev.Cleared = true
+				changed = append(changed, prev)
+			}
+		}
+	}
+	return changed
+}
+
+func (a *Alerter) check(kind AlertKind, e Entry, now time.Time) (string, bool) {
+	switch kind {
+	case AlertSilent:
+		age := now.Sub(e.Last.Time)
+		if a.Config.Silent > 0 && age >= a.Config.Silent {
+			return fmt.Sprintf("not heard from for %s", FormatAge(age)), true
+		}
+	case AlertBattery:
+		if len(e.Battery) == 0 {
+			// Not on battery power
+			return "", false
+		}
+		if a.Config.Battery > 0 && e.Last.Battery <= a.Config.Battery {
+			return fmt.Sprintf("battery at %d%%", e.Last.Battery), true
+		}
+		if a.Config.BatteryWithin > 0 {
+			est, ok := e.BatteryEstimate()
+			if ok && est.Empty.Sub(now) <= a.Config.BatteryWithin {
+				return fmt.Sprintf("battery at %d%% estimated empty in %s", e.Last.Battery, FormatAge(est.Empty.Sub(now))), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Active returns the active alerts, sorted by MAC
+func (a *Alerter) Active() []Alert {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	alerts := make([]Alert, 0, len(a.active))
+	for _, alert := range a.active {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].MAC != alerts[j].MAC {
+			return alerts[i].MAC < alerts[j].MAC
+		}
+		return alerts[i].Kind < alerts[j].Kind
+	})
+	return alerts
+}
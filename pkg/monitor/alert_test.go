@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func batteryObservation(mac string, level int32, at time.Time) Observation {
+	return Observation{
+		Time:        at,
+		MAC:         mac,
+		Name:        "Living",
+		Message:     sensor.MessageType_DATA,
+		Battery:     level,
+		PowerSource: int32(sensor.PowerSource_BATTERY),
+	}
+}
+
+func TestBatteryEstimate(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tracker := NewTracker(10)
+	// 1% every 2 days, reported every hour
+	for h := 0; h <= 20*24; h++ {
+		at := start.Add(time.Duration(h) * time.Hour)
+		tracker.Add(batteryObservation("a", 90-int32(h/48), at))
+	}
+	e, _ := tracker.Entry("a")
+	assert.Len(t, e.Battery, 11, "Only changes are kept")
+	est, ok := e.BatteryEstimate()
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5, est.PerDay, 0.02)
+	assert.WithinDuration(t, start.Add(180*day), est.Empty, 5*day)
+
+	tracker.Add(batteryObservation("a", 100, start.Add(21*day)))
+	e, _ = tracker.Entry("a")
+	assert.Len(t, e.Battery, 1, "A new battery starts over")
+	_, ok = e.BatteryEstimate()
+	assert.False(t, ok, "Not enough samples")
+
+	_, ok = EstimateBattery([]BatterySample{{start, 50}, {start.Add(day), 51}}, 51, start.Add(2*day))
+	assert.False(t, ok, "Not draining")
+
+	var flapping []BatterySample
+	for h := 0; h < 200; h++ {
+		flapping = append(flapping, BatterySample{start.Add(time.Duration(h) * time.Hour), 90 - int32(h%2)})
+	}
+	_, ok = EstimateBattery(flapping, 89, start.Add(200*time.Hour))
+	assert.False(t, ok, "Too flat to estimate")
+
+	o := batteryObservation("b", 50, start)
+	o.PowerSource = int32(sensor.PowerSource_AC)
+	tracker.Add(o)
+	e, _ = tracker.Entry("b")
+	assert.Empty(t, e.Battery, "AC powered")
+}
+
+func TestAlerter(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(10)
+	alerter := NewAlerter(AlertConfig{Silent: time.Hour, Battery: 20})
+	tracker.Add(batteryObservation("a", 50, start))
+	tracker.Add(batteryObservation("b", 25, start))
+	assert.Empty(t, alerter.Check(tracker.Entries(), start.Add(time.Minute)))
+
+	alerts := alerter.Check(tracker.Entries(), start.Add(2*time.Hour))
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, AlertSilent, alerts[0].Kind)
+	assert.Equal(t, "a", alerts[0].MAC)
+	assert.Equal(t, "Living (a): not heard from for 2h00m", alerts[0].String())
+	assert.Equal(t, "b", alerts[1].MAC)
+	assert.Empty(t, alerter.Check(tracker.Entries(), start.Add(3*time.Hour)), "Only reported once")
+	assert.Contains(t, alerter.Active()[0].Message, "3h00m", "Message is kept current")
+
+	tracker.Add(batteryObservation("b", 20, start.Add(3*time.Hour)))
+	alerts = alerter.Check(tracker.Entries(), start.Add(3*time.Hour))
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, AlertSilent, alerts[0].Kind)
+	assert.True(t, alerts[0].Cleared, "Heard from again")
+	assert.Equal(t, "Living (b): silent alert cleared", alerts[0].String())
+	assert.Equal(t, AlertBattery, alerts[1].Kind)
+	assert.Equal(t, "b", alerts[1].MAC)
+	assert.Equal(t, "battery at 20%", alerts[1].Message)
+	assert.Len(t, alerter.Active(), 2)
+
+	// Draining 5% a day with 25% left
+	alerter = NewAlerter(AlertConfig{BatteryWithin: 7 * 24 * time.Hour})
+	tracker = NewTracker(10)
+	for d := 0; d <= 5; d++ {
+		tracker.Add(batteryObservation("c", 50-5*int32(d), start.Add(time.Duration(d)*24*time.Hour)))
+	}
+	alerts = alerter.Check(tracker.Entries(), start.Add(5*24*time.Hour))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "battery at 25% estimated empty in 5d00h", alerts[0].Message)
+}
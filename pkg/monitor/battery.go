@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+const (
+	// maxBatterySamples bounds the battery levels kept per MAC
+	maxBatterySamples = 200
+	// batteryReplaced is how far the level has to jump up to count as a new battery
+	batteryReplaced = 10
+	// minBatterySpan is the least time the samples must span to estimate from
+	minBatterySpan = time.Hour
+	// maxBatteryDays is the furthest out an estimate is believed, a nearly
+	// flat fit would otherwise overflow a time.Duration
+	maxBatteryDays = 5 * 365
+)
+
+// BatterySample is the battery level reported at a time
+type BatterySample struct {
+	Time  time.Time
+	Level int32
+}
+
+// addBatterySample records a level when it changes, starting over when the
+// battery is replaced
+func addBatterySample(samples []BatterySample, o Observation) []BatterySample {
+	if o.PowerSource != int32(sensor.PowerSource_BATTERY) {
+		return nil
+	}
+	if len(samples) > 0 {
+		last := samples[len(samples)-1]
+		if o.Battery == last.Level {
+			return samples
+		}
+		if o.Battery-last.Level >= batteryReplaced {
+			samples = nil
+		}
+	}
+	samples = append(samples, BatterySample{Time: o.Time, Level: o.Battery})
+	if len(samples) > maxBatterySamples {
+		samples = append([]BatterySample(nil), samples[len(samples)-maxBatterySamples:]...)
+	}
+	return samples
+}
+
+// BatteryEstimate is a battery's drain from a linear fit of its levels
+type BatteryEstimate struct {
+	// PerDay is the fitted drain in percent per day
+	PerDay float64
+	// Empty is when the fit reaches 0%
+	Empty time.Time
+}
+
+// EstimateBattery fits a line to the samples, with the level last seen at now
+// as the final sample. It needs samples spanning at least an hour with the
+// level going down fast enough to be empty within five years.
+func EstimateBattery(samples []BatterySample, level int32, now time.Time) (BatteryEstimate, bool) {
+	if len(samples) == 0 {
+		return BatteryEstimate{}, false
+	}
+	samples = append(samples[:len(samples):len(samples)], BatterySample{Time: now, Level: level})
+	start := samples[0].Time
+	if now.Sub(start) < minBatterySpan {
+		return BatteryEstimate{}, false
+	}
+	// Least squares over days since the first sample
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.Time.Sub(start).Hours() / 24
+		y := float64(s.Level)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(samples))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return BatteryEstimate{}, false
+	}
+	slope := (n*sxy - sx*sy) / d
+	if slope >= 0 {
+		return BatteryEstimate{}, false
+	}
+	intercept := (sy - slope*sx) / n
+	days := -intercept / slope
+	if days > maxBatteryDays {
+		return BatteryEstimate{}, false
+	}
+	return BatteryEstimate{
+		PerDay: -slope,
+		Empty:  start.Add(time.Duration(days * 24 * float64(time.Hour))),
+	}, true
+}
+
+// BatteryEstimate estimates the entry's battery drain, see EstimateBattery
+func (e Entry) BatteryEstimate() (BatteryEstimate, bool) {
+	return EstimateBattery(e.Battery, e.Last.Battery, e.Last.Time)
+}
@@ -0,0 +1,266 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// HVACMode is what an HVAC unit is doing, judged from its supply and return
+// temperatures
+type HVACMode string
+
+const (
+	HVACIdle    HVACMode = "idle"
+	HVACCooling HVACMode = "cooling"
+	HVACHeating HVACMode = "heating"
+)
+
+// DeltaTState is how a unit's temperature split compares to its normal range
+type DeltaTState string
+
+const (
+	// DeltaTIdle is a unit that isn't heating or cooling
+	DeltaTIdle DeltaTState = "idle"
+	// DeltaTSettling is a unit that hasn't been running long enough to judge
+	DeltaTSettling DeltaTState = "settling"
+	DeltaTNormal   DeltaTState = "normal"
+	DeltaTLow      DeltaTState = "low"
+	DeltaTHigh     DeltaTState = "high"
+	// DeltaTStale is a unit missing a recent supply or return reading
+	DeltaTStale DeltaTState = "stale"
+	// DeltaTCollapsed is a unit whose split fell into the idle band after a
+	// cooling run and stayed there without closing. A unit cooling too little to
+	// leave the idle band looks the same as an idle one, this catches the split
+	// collapsing once it's been seen running.
+	DeltaTCollapsed DeltaTState = "collapsed"
+)
+
+// DeltaTConfig sets how supply/return splits are judged, in Fahrenheit
+type DeltaTConfig struct {
+	// IdleBand is the largest split that counts as idle
+	IdleBand float64
+	// Settle is how long a unit has to be heating or cooling before its split is judged
+	Settle time.Duration
+	// MaxAge is how old a supply or return reading can be to be used
+	MaxAge time.Duration
+	// CoolingMin and CoolingMax are the normal return minus supply range while cooling
+	CoolingMin float64
+	CoolingMax float64
+	// HeatingMin and HeatingMax are the normal supply minus return range while heating
+	HeatingMin float64
+	HeatingMax float64
+	// Verified only uses readings signed with a key from the key store, see
+	// Trusted
+	Verified bool
+}
+
+// DefaultDeltaTConfig uses the usual rules of thumb: a 14-22F drop across
+// an air conditioner's coil and a 25-70F rise across a furnace. Heat pumps
+// heat with a smaller rise, around 15-30F.
+func DefaultDeltaTConfig() DeltaTConfig {
+	return DeltaTConfig{
+		IdleBand:   4,
+		Settle:     10 * time.Minute,
+		MaxAge:     15 * time.Minute,
+		CoolingMin: 14,
+		CoolingMax: 22,
+		HeatingMin: 25,
+		HeatingMax: 70,
+	}
+}
+
+// DeltaTSample is a unit's split at a time
+type DeltaTSample struct {
+	Time   time.Time `json:"time"`
+	Supply float64   `json:"supply"`
+	Return float64   `json:"return"`
+	Mode   HVACMode  `json:"mode"`
+}
+
+// Split is supply minus return, positive while heating and negative while cooling
+func (s DeltaTSample) Split() float64 {
+	return s.Supply - s.Return
+}
+
+// DeltaT is the size of the split
+func (s DeltaTSample) DeltaT() float64 {
+	return math.Abs(s.Split())
+}
+
+// DeltaTStatus is the latest split for an HVAC unit
+type DeltaTStatus struct {
+	UnitID int32 `json:"unitId"`
+	DeltaTSample
+	// Since is when the unit started its current mode
+	Since   time.Time   `json:"since"`
+	State   DeltaTState `json:"state"`
+	Message string      `json:"message,omitempty"`
+	// History is the most recent samples, oldest first
+	History []DeltaTSample `json:"history,omitempty"`
+}
+
+// Abnormal is whether the split is outside its normal range
+func (s DeltaTStatus) Abnormal() bool {
+	return s.State == DeltaTLow || s.State == DeltaTHigh || s.State == DeltaTCollapsed
+}
+
+func (s DeltaTStatus) String() string {
+	line := fmt.Sprintf("Unit %d %s: supply %.1fF, return %.1fF, delta-T %.1fF, %s",
+		s.UnitID, s.Mode, s.Supply, s.Return, s.DeltaT(), s.State)
+	if s.Message != "" {
+		line += ", " + s.Message
+	}
+	return line
+}
+
+type deltaTUnit struct {
+	supply  *Observation
+	ret     *Observation
+	mode    HVACMode
+	since   time.Time
+	history []DeltaTSample
+	// cooled is when the unit was last seen cooling after settling, zero once
+	// its split has closed
+	cooled time.Time
+}
+
+// DeltaTAnalyzer follows the SUPPLY and RETURN sensors of each HVAC unit, by
+// unit ID, and judges the temperature split between them
+type DeltaTAnalyzer struct {
+	Config DeltaTConfig
+	// HistorySize is how many samples are kept per unit, negative is treated
+	// as zero
+	HistorySize int
+
+	lock  sync.Mutex
+	units map[int32]*deltaTUnit
+}
+
+func NewDeltaTAnalyzer(c DeltaTConfig, historySize int) *DeltaTAnalyzer {
+	return &DeltaTAnalyzer{Config: c, HistorySize: historySize, units: make(map[int32]*deltaTUnit)}
+}
+
+// Add records a supply or return reading, returning the unit's updated status
+// when it has both. Other readings, and ones that aren't Trusted, are ignored.
+func (a *DeltaTAnalyzer) Add(o Observation) (DeltaTStatus, bool) {
+	if o.Message != sensor.MessageType_DATA || (o.Type != sensor.SensorType_SUPPLY && o.Type != sensor.SensorType_RETURN) || !Trusted(o, a.Config.Verified) {
+		return DeltaTStatus{}, false
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u, ok := a.units[o.UnitID]
+	if !ok {
+		u = &deltaTUnit{mode: HVACIdle}
+		a.units[o.UnitID] = u
+	}
+	if o.Type == sensor.SensorType_SUPPLY {
+		u.supply = &o
+	} else {
+		u.ret = &o
+	}
+	if u.supply == nil || u.ret == nil || a.stale(u, o.Time) {
+		return DeltaTStatus{}, false
+	}
+	sample := DeltaTSample{Time: o.Time, Supply: u.supply.Temperature, Return: u.ret.Temperature}
+	sample.Mode = a.mode(sample.Split())
+	if sample.Mode != u.mode || u.since.IsZero() {
+		u.mode = sample.Mode
+		u.since = o.Time
+	}
+	switch {
+	case sample.Mode == HVACCooling && o.Time.Sub(u.since) >= a.Config.Settle:
+		u.cooled = o.Time
+	case sample.Mode != HVACIdle || !a.residual(sample.Split()):
+		// Heating, or the split closed so the cooling run is over
+		u.cooled = time.Time{}
+	}
+	u.history = append(u.history, sample)
+	if n := keep(a.HistorySize); len(u.history) > n {
+		u.history = append([]DeltaTSample(nil), u.history[len(u.history)-n:]...)
+	}
+	return a.status(o.UnitID, u, o.Time), true
+}
+
+func (a *DeltaTAnalyzer) stale(u *deltaTUnit, now time.Time) bool {
+	return a.Config.MaxAge > 0 && (now.Sub(u.supply.Time) > a.Config.MaxAge || now.Sub(u.ret.Time) > a.Config.MaxAge)
+}
+
+func (a *DeltaTAnalyzer) mode(split float64) HVACMode {
+	switch {
+	case split > a.Config.IdleBand:
+		return HVACHeating
+	case split < -a.Config.IdleBand:
+		return HVACCooling
+	default:
+		return HVACIdle
+	}
+}
+
+// residual is whether an idle split is still at least half the idle band in the
+// cooling direction, which it doesn't stay at for long after a unit stops
+func (a *DeltaTAnalyzer) residual(split float64) bool {
+	return split <= -a.Config.IdleBand/2
+}
+
+func (a *DeltaTAnalyzer) status(unit int32, u *deltaTUnit, now time.Time) DeltaTStatus {
+	s := DeltaTStatus{
+		UnitID:  unit,
+		Since:   u.since,
+		History: append([]DeltaTSample(nil), u.history...),
+	}
+	if len(u.history) > 0 {
+		s.DeltaTSample = u.history[len(u.history)-1]
+	}
+	switch {
+	case a.stale(u, now):
+		s.State = DeltaTStale
+	case s.Mode == HVACIdle && !u.cooled.IsZero() && now.Sub(u.cooled) >= a.Config.Settle:
+		s.State = DeltaTCollapsed
+		s.Message = fmt.Sprintf("split fell to %.1fF after cooling and hasn't closed, if the unit is still running check for low refrigerant charge or an iced evaporator coil", s.DeltaT())
+	case s.Mode == HVACIdle:
+		s.State = DeltaTIdle
+	case now.Sub(u.since) < a.Config.Settle:
+		s.State = DeltaTSettling
+	case s.Mode == HVACCooling:
+		s.State, s.Message = judge(s.DeltaT(), a.Config.CoolingMin, a.Config.CoolingMax,
+			"low refrigerant charge or a dirty or iced evaporator coil",
+			"restricted airflow, like a clogged filter or closed vents")
+	default:
+		s.State, s.Message = judge(s.DeltaT(), a.Config.HeatingMin, a.Config.HeatingMax,
+			"low heat output, or too much airflow",
+			"restricted airflow, like a clogged filter or closed vents")
+	}
+	return s
+}
+
+func judge(deltaT, min, max float64, low, high string) (DeltaTState, string) {
+	switch {
+	case deltaT < min:
+		return DeltaTLow, fmt.Sprintf("below %.0fF, check for %s", min, low)
+	case deltaT > max:
+		return DeltaTHigh, fmt.Sprintf("above %.0fF, check for %s", max, high)
+	default:
+		return DeltaTNormal, ""
+	}
+}
+
+// Units returns the status of every unit with both a supply and a return
+// reading as of now, sorted by unit ID
+func (a *DeltaTAnalyzer) Units(now time.Time) []DeltaTStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var statuses []DeltaTStatus
+	for unit, u := range a.units {
+		if len(u.history) == 0 {
+			continue
+		}
+		statuses = append(statuses, a.status(unit, u, now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].UnitID < statuses[j].UnitID })
+	return statuses
+}
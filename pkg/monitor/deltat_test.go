@@ -0,0 +1,167 @@
+package monitor
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func hvacObservation(t sensor.SensorType, unit int32, temp float64, at time.Time) Observation {
+	return Observation{
+		Time:        at,
+		MAC:         t.String(),
+		Name:        t.String(),
+		Type:        t,
+		UnitID:      unit,
+		Message:     sensor.MessageType_DATA,
+		Temperature: temp,
+	}
+}
+
+func TestDeltaT(t *testing.T) {
+	start := time.Date(2022, 7, 1, 14, 0, 0, 0, time.UTC)
+	a := NewDeltaTAnalyzer(DefaultDeltaTConfig(), 10)
+	add := func(typ sensor.SensorType, temp float64, minutes int) (DeltaTStatus, bool) {
+		return a.Add(hvacObservation(typ, 1, temp, start.Add(time.Duration(minutes)*time.Minute)))
+	}
+
+	_, ok := add(sensor.SensorType_RETURN, 75, 0)
+	assert.False(t, ok, "No supply reading yet")
+	_, ok = a.Add(hvacObservation(sensor.SensorType_REMOTE, 1, 60, start))
+	assert.False(t, ok, "Other sensors are ignored")
+
+	s, ok := add(sensor.SensorType_SUPPLY, 73, 1)
+	assert.True(t, ok)
+	assert.Equal(t, HVACIdle, s.Mode)
+	assert.Equal(t, DeltaTIdle, s.State)
+
+	s, _ = add(sensor.SensorType_SUPPLY, 60, 2)
+	assert.Equal(t, HVACCooling, s.Mode)
+	assert.Equal(t, DeltaTSettling, s.State)
+	assert.Equal(t, -15.0, s.Split())
+	assert.Equal(t, start.Add(2*time.Minute), s.Since)
+
+	// Low split once settled
+	s, _ = add(sensor.SensorType_SUPPLY, 65, 13)
+	assert.Equal(t, DeltaTLow, s.State)
+	assert.True(t, s.Abnormal())
+	assert.Contains(t, s.Message, "refrigerant")
+	assert.Equal(t, "Unit 1 cooling: supply 65.0F, return 75.0F, delta-T 10.0F, low, below 14F, check for low refrigerant charge or a dirty or iced evaporator coil", s.String())
+	assert.Len(t, s.History, 3)
+
+	s, _ = add(sensor.SensorType_SUPPLY, 57, 14)
+	assert.Equal(t, DeltaTNormal, s.State)
+	add(sensor.SensorType_RETURN, 75, 15)
+	s, _ = add(sensor.SensorType_SUPPLY, 50, 15)
+	assert.Equal(t, DeltaTHigh, s.State)
+	assert.Contains(t, s.Message, "clogged filter")
+
+	s, _ = add(sensor.SensorType_SUPPLY, 130, 16)
+	assert.Equal(t, HVACHeating, s.Mode)
+	assert.Equal(t, DeltaTSettling, s.State, "Mode changed")
+	units := a.Units(start.Add(31 * time.Minute))
+	assert.Len(t, units, 1)
+	assert.Equal(t, DeltaTStale, units[0].State, "Return reading is too old")
+
+	_, ok = add(sensor.SensorType_SUPPLY, 130, 31)
+	assert.False(t, ok, "Stale return reading isn't used")
+	s, _ = add(sensor.SensorType_RETURN, 68, 32)
+	assert.Equal(t, DeltaTNormal, s.State)
+	assert.Equal(t, 62.0, s.DeltaT())
+}
+
+func TestDeltaTTrusted(t *testing.T) {
+	at := time.Date(2022, 7, 1, 14, 0, 0, 0, time.UTC)
+	spoofed := hvacObservation(sensor.SensorType_SUPPLY, 1, 40, at)
+	spoofed.Signature = sensor.SignatureInvalid
+	a := NewDeltaTAnalyzer(DefaultDeltaTConfig(), 10)
+	a.Add(hvacObservation(sensor.SensorType_RETURN, 1, 75, at))
+	a.Add(hvacObservation(sensor.SensorType_SUPPLY, 1, 74, at))
+	_, ok := a.Add(spoofed)
+	assert.False(t, ok, "Bad signatures are ignored")
+	assert.Equal(t, 74.0, a.Units(at)[0].Supply)
+
+	c := DefaultDeltaTConfig()
+	c.Verified = true
+	a = NewDeltaTAnalyzer(c, 10)
+	a.Add(hvacObservation(sensor.SensorType_RETURN, 1, 75, at))
+	_, ok = a.Add(hvacObservation(sensor.SensorType_SUPPLY, 1, 74, at))
+	assert.False(t, ok, "No key")
+	assert.Empty(t, a.Units(at))
+}
+
+func TestDeltaTCollapsed(t *testing.T) {
+	start := time.Date(2022, 7, 1, 14, 0, 0, 0, time.UTC)
+	a := NewDeltaTAnalyzer(DefaultDeltaTConfig(), 10)
+	add := func(typ sensor.SensorType, temp float64, minutes int) DeltaTStatus {
+		s, _ := a.Add(hvacObservation(typ, 1, temp, start.Add(time.Duration(minutes)*time.Minute)))
+		return s
+	}
+
+	add(sensor.SensorType_RETURN, 75, 0)
+	add(sensor.SensorType_SUPPLY, 57, 0)
+	s := add(sensor.SensorType_SUPPLY, 57, 12)
+	assert.Equal(t, DeltaTNormal, s.State)
+
+	// An undercharged unit's split falls into the idle band but doesn't close
+	s = add(sensor.SensorType_SUPPLY, 72, 14)
+	assert.Equal(t, HVACIdle, s.Mode)
+	assert.Equal(t, DeltaTIdle, s.State, "Could have just stopped")
+	add(sensor.SensorType_RETURN, 75, 22)
+	s = add(sensor.SensorType_SUPPLY, 72, 22)
+	assert.Equal(t, DeltaTCollapsed, s.State)
+	assert.True(t, s.Abnormal())
+	assert.Contains(t, s.Message, "refrigerant")
+
+	s = add(sensor.SensorType_SUPPLY, 74.5, 23)
+	assert.Equal(t, DeltaTIdle, s.State, "Split closed")
+	add(sensor.SensorType_RETURN, 75, 40)
+	s = add(sensor.SensorType_SUPPLY, 72, 40)
+	assert.Equal(t, DeltaTIdle, s.State, "No cooling run since the split closed")
+
+	// A unit that stops normally closes its split before it settles
+	b := NewDeltaTAnalyzer(DefaultDeltaTConfig(), 10)
+	for _, o := range []Observation{
+		hvacObservation(sensor.SensorType_RETURN, 1, 75, start),
+		hvacObservation(sensor.SensorType_SUPPLY, 1, 57, start),
+		hvacObservation(sensor.SensorType_SUPPLY, 1, 57, start.Add(12*time.Minute)),
+		hvacObservation(sensor.SensorType_SUPPLY, 1, 72, start.Add(14*time.Minute)),
+		hvacObservation(sensor.SensorType_SUPPLY, 1, 74, start.Add(18*time.Minute)),
+		hvacObservation(sensor.SensorType_RETURN, 1, 75, start.Add(30*time.Minute)),
+		hvacObservation(sensor.SensorType_SUPPLY, 1, 74.5, start.Add(30*time.Minute)),
+	} {
+		s, _ = b.Add(o)
+	}
+	assert.Equal(t, DeltaTIdle, s.State)
+}
+
+func TestWriteMetrics(t *testing.T) {
+	at := time.Unix(1656684000, 0)
+	tracker := NewTracker(10)
+	a := NewDeltaTAnalyzer(DefaultDeltaTConfig(), 10)
+	odd := hvacObservation(sensor.SensorType_REMOTE, 1, 70, at)
+	odd.Name = "Den \"east\"\\\nwall"
+	for _, o := range []Observation{
+		hvacObservation(sensor.SensorType_RETURN, 2, 75, at),
+		hvacObservation(sensor.SensorType_SUPPLY, 2, 57, at),
+		odd,
+	} {
+		tracker.Add(o)
+		a.Add(o)
+	}
+	var b bytes.Buffer
+	WriteMetrics(&b, tracker.Entries(), a.Units(at))
+	out := b.String()
+	assert.Contains(t, out, "# TYPE tstat_observed_messages_total counter\n")
+	assert.Contains(t, out, `tstat_observed_temperature_fahrenheit{mac="SUPPLY",name="SUPPLY",type="SUPPLY",unit="2"} 57`+"\n")
+	assert.Contains(t, out, `tstat_observed_last_seen_timestamp_seconds{mac="RETURN",name="RETURN",type="RETURN",unit="2"} 1.656684e+09`+"\n")
+	assert.Contains(t, out, `tstat_hvac_delta_t_fahrenheit{unit="2"} 18`+"\n")
+	assert.Contains(t, out, `tstat_hvac_mode{unit="2",mode="cooling"} 1`+"\n")
+	assert.Contains(t, out, `tstat_hvac_mode{unit="2",mode="heating"} 0`+"\n")
+	assert.Contains(t, out, `tstat_hvac_delta_t_state{unit="2",state="settling"} 1`+"\n")
+	assert.Contains(t, out, `tstat_hvac_delta_t_abnormal{unit="2"} 0`+"\n")
+	assert.Contains(t, out, `tstat_observed_temperature_fahrenheit{mac="REMOTE",name="Den \"east\"\\\nwall",type="REMOTE",unit="1"} 70`+"\n", "Labels are escaped")
+}
@@ -0,0 +1,153 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Filter selects observations. Within each criterion any value can match, every
+// criterion that's set has to match. The zero Filter matches everything.
+type Filter struct {
+	// MACs are wire MACs
+	MACs       []string
+	Names      []*regexp.Regexp
+	Types      []sensor.SensorType
+	UnitIDs    []int32
+	Messages   []sensor.MessageType
+	Networks   []*net.IPNet
+	Signatures []sensor.SignatureStatus
+}
+
+// AddMAC adds a MAC in any form ParseMAC accepts, or a wire MAC as is
+func (f *Filter) AddMAC(s string) {
+	if mac, err := sensor.ParseMAC(s); err == nil {
+		s = mac.String()
+	}
+	f.MACs = append(f.MACs, strings.ToLower(s))
+}
+
+// AddNameGlob adds a case insensitive name pattern where * matches anything
+// and ? any single character
+func (f *Filter) AddNameGlob(glob string) {
+	re := regexp.QuoteMeta(glob)
+	re = strings.Replace(re, `\*`, ".*", -1)
+	re = strings.Replace(re, `\?`, ".", -1)
+	f.Names = append(f.Names, regexp.MustCompile("(?i)^"+re+"$"))
+}
+
+// AddNameRegexp adds a name regular expression, it can match any part of the name
+func (f *Filter) AddNameRegexp(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("invalid name regexp [%s]: %w", s, err)
+	}
+	f.Names = append(f.Names, re)
+	return nil
+}
+
+// AddMessageType adds a message type by name (pair, data)
+func (f *Filter) AddMessageType(s string) error {
+	v, ok := sensor.MessageType_value[strings.ToUpper(s)]
+	if !ok {
+		return fmt.Errorf("invalid message type [%s]", s)
+	}
+	f.Messages = append(f.Messages, sensor.MessageType(v))
+	return nil
+}
+
+// AddNetwork adds an IP address or CIDR network senders have to be from
+func (f *Filter) AddNetwork(s string) error {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address [%s]", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		f.Networks = append(f.Networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return fmt.Errorf("invalid network [%s]: %w", s, err)
+	}
+	f.Networks = append(f.Networks, n)
+	return nil
+}
+
+// Match reports whether o passes the filter
+func (f *Filter) Match(o Observation) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.MACs) > 0 && !matchAny(len(f.MACs), func(i int) bool { return f.MACs[i] == strings.ToLower(o.MAC) }) {
+		return false
+	}
+	if len(f.Names) > 0 && !matchAny(len(f.Names), func(i int) bool { return f.Names[i].MatchString(o.Name) }) {
+		return false
+	}
+	if len(f.Types) > 0 && !matchAny(len(f.Types), func(i int) bool { return f.Types[i] == o.Type }) {
+		return false
+	}
+	if len(f.UnitIDs) > 0 && !matchAny(len(f.UnitIDs), func(i int) bool { return f.UnitIDs[i] == o.UnitID }) {
+		return false
+	}
+	if len(f.Messages) > 0 && !matchAny(len(f.Messages), func(i int) bool { return f.Messages[i] == o.Message }) {
+		return false
+	}
+	if len(f.Signatures) > 0 && !matchAny(len(f.Signatures), func(i int) bool { return f.Signatures[i] == o.Signature }) {
+		return false
+	}
+	if len(f.Networks) > 0 {
+		ip := fromIP(o.From)
+		if ip == nil || !matchAny(len(f.Networks), func(i int) bool { return f.Networks[i].Contains(ip) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(n int, match func(i int) bool) bool {
+	for i := 0; i < n; i++ {
+		if match(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromIP is the IP of a sender address, nil if it doesn't have one
+func fromIP(from string) net.IP {
+	host, _, err := net.SplitHostPort(from)
+	if err != nil {
+		host = from
+	}
+	return net.ParseIP(host)
+}
+
+// Receive listens on t and calls handle for each message that matches filter
+// (nil matches everything). Every message's signature is checked with ring,
+// even ones filtered out, so keys from pairing messages are always learned.
+// Packets that can't be decoded are passed to handle with a nil msg and the
+// error, otherwise err explains an invalid signature. It returns when t does.
+func Receive(t sensor.Transport, ring *sensor.KeyRing, filter *Filter, handle func(o Observation, msg *sensor.SensorMsg, err error)) error {
+	return sensor.Listen(t, func(msg *sensor.SensorMsg, from net.Addr, err error) {
+		if err != nil {
+			handle(Observation{}, nil, err)
+			return
+		}
+		sig, sigErr := ring.Check(msg)
+		o := Observe(msg, from, sig, time.Now())
+		if !filter.Match(o) {
+			return
+		}
+		handle(o, msg, sigErr)
+	})
+}
@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	o := Observation{
+		MAC:       "0a1b2c3d4e5f",
+		Name:      "Living Room",
+		Type:      sensor.SensorType_REMOTE,
+		UnitID:    2,
+		Message:   sensor.MessageType_DATA,
+		From:      "192.168.1.20:5001",
+		Signature: sensor.SignatureValid,
+	}
+	var nilFilter *Filter
+	assert.True(t, nilFilter.Match(o))
+	assert.True(t, (&Filter{}).Match(o))
+
+	test := func(name string, match bool, build func(f *Filter) error) {
+		t.Run(name, func(t *testing.T) {
+			f := &Filter{}
+			assert.NoError(t, build(f))
+			assert.Equal(t, match, f.Match(o))
+		})
+	}
+	test("mac", true, func(f *Filter) error { f.AddMAC("0A:1B:2C:3D:4E:5F"); return nil })
+	test("other mac", false, func(f *Filter) error { f.AddMAC("0a1b2c3d4e50"); return nil })
+	test("any mac", true, func(f *Filter) error { f.AddMAC("0a1b2c3d4e50"); f.AddMAC("0a-1b-2c-3d-4e-5f"); return nil })
+	test("glob", true, func(f *Filter) error { f.AddNameGlob("living*"); return nil })
+	test("glob whole name", false, func(f *Filter) error { f.AddNameGlob("Room"); return nil })
+	test("regexp", true, func(f *Filter) error { return f.AddNameRegexp("Ro+m$") })
+	test("type", false, func(f *Filter) error { f.Types = []sensor.SensorType{sensor.SensorType_OUTDOOR}; return nil })
+	test("unit", true, func(f *Filter) error { f.UnitIDs = []int32{1, 2}; return nil })
+	test("message", false, func(f *Filter) error { return f.AddMessageType("pair") })
+	test("cidr", true, func(f *Filter) error { return f.AddNetwork("192.168.1.0/24") })
+	test("ip", false, func(f *Filter) error { return f.AddNetwork("192.168.1.21") })
+	test("signature", false, func(f *Filter) error { f.Signatures = []sensor.SignatureStatus{sensor.SignatureInvalid}; return nil })
+	test("all", false, func(f *Filter) error { f.AddNameGlob("living*"); f.UnitIDs = []int32{3}; return nil })
+
+	f := &Filter{}
+	assert.Error(t, f.AddNameRegexp("("))
+	assert.Error(t, f.AddMessageType("hello"))
+	assert.Error(t, f.AddNetwork("192.168.1"))
+	assert.Error(t, f.AddNetwork("192.168.1.0/33"))
+}
+
+func TestReceive(t *testing.T) {
+	tr := sensor.NewMemoryTransport(10)
+	key := []byte("key")
+	for _, name := range []string{"A", "B"} {
+		for _, pair := range []bool{true, false} {
+			_, data, err := sensor.SimpleBuild(sensor.Temperature{Value: 70}, name, pair, sensor.MAC{}, key, sensor.SensorType_REMOTE, 1, 1)
+			assert.NoError(t, err)
+			assert.NoError(t, tr.WritePacket(data))
+		}
+	}
+	assert.NoError(t, tr.WritePacket([]byte("garbage")))
+	assert.NoError(t, tr.Close())
+
+	f := &Filter{}
+	f.AddNameGlob("b")
+	assert.NoError(t, f.AddMessageType("data"))
+	var got []Observation
+	errs := 0
+	err := Receive(tr, sensor.NewKeyRing(), f, func(o Observation, msg *sensor.SensorMsg, err error) {
+		if msg == nil {
+			errs++
+			return
+		}
+		got = append(got, o)
+	})
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.Equal(t, 1, errs)
+	assert.Len(t, got, 1)
+	assert.Equal(t, "B", got[0].Name)
+	assert.Equal(t, sensor.SignaturePairKey, got[0].Signature, "Key learned from the filtered out pairing message")
+}
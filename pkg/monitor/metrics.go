@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/metrics"
+)
+
+// Handler serves the tracked sensors and HVAC units as Prometheus metrics at /metrics
+func Handler(tracker *Tracker, deltaT *DeltaTAnalyzer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		WriteMetrics(w, tracker.Entries(), deltaT.Units(time.Now()))
+	})
+	return mux
+}
+
+// WriteMetrics writes observed sensors and HVAC unit splits in the Prometheus
+// text format
+func WriteMetrics(w io.Writer, entries []Entry, units []DeltaTStatus) {
+	metric := func(name, kind, help string, samples []metrics.Sample) {
+		metrics.Write(w, name, kind, help, samples)
+	}
+	unit := func(id int32) metrics.Label {
+		return metrics.Label{Name: "unit", Value: fmt.Sprint(id)}
+	}
+	sensors := func(f func(e Entry) float64) []metrics.Sample {
+		var samples []metrics.Sample
+		for _, e := range entries {
+			labels := []metrics.Label{
+				{Name: "mac", Value: e.Last.MAC},
+				{Name: "name", Value: e.Last.Name},
+				{Name: "type", Value: e.Last.Type.String()},
+				unit(e.Last.UnitID),
+			}
+			samples = append(samples, metrics.Sample{Labels: labels, Value: f(e)})
+		}
+		return samples
+	}
+	hvac := func(f func(u DeltaTStatus) float64) []metrics.Sample {
+		var samples []metrics.Sample
+		for _, u := range units {
+			samples = append(samples, metrics.Sample{Labels: []metrics.Label{unit(u.UnitID)}, Value: f(u)})
+		}
+		return samples
+	}
+
+	metric("tstat_observed_messages_total", "counter", "Messages received from each sensor", sensors(func(e Entry) float64 {
+		return float64(e.Count)
+	}))
+	metric("tstat_observed_last_seen_timestamp_seconds", "gauge", "When each sensor was last heard from", sensors(func(e Entry) float64 {
+		return float64(e.Last.Time.UnixNano()) / 1e9
+	}))
+	metric("tstat_observed_temperature_fahrenheit", "gauge", "Last temperature each sensor reported", sensors(func(e Entry) float64 {
+		return e.Last.Temperature
+	}))
+	metric("tstat_observed_battery_percent", "gauge", "Last battery level each sensor reported", sensors(func(e Entry) float64 {
+		return float64(e.Last.Battery)
+	}))
+
+	metric("tstat_hvac_supply_fahrenheit", "gauge", "Supply air temperature of each HVAC unit", hvac(func(u DeltaTStatus) float64 {
+		return u.Supply
+	}))
+	metric("tstat_hvac_return_fahrenheit", "gauge", "Return air temperature of each HVAC unit", hvac(func(u DeltaTStatus) float64 {
+		return u.Return
+	}))
+	metric("tstat_hvac_delta_t_fahrenheit", "gauge", "Difference between supply and return temperatures", hvac(func(u DeltaTStatus) float64 {
+		return u.DeltaT()
+	}))
+	var modes, states []metrics.Sample
+	for _, u := range units {
+		for _, m := range []HVACMode{HVACIdle, HVACCooling, HVACHeating} {
+			labels := []metrics.Label{unit(u.UnitID), {Name: "mode", Value: string(m)}}
+			modes = append(modes, metrics.Sample{Labels: labels, Value: metrics.Bool(u.Mode == m)})
+		}
+		for _, s := range []DeltaTState{DeltaTIdle, DeltaTSettling, DeltaTNormal, DeltaTLow, DeltaTHigh, DeltaTCollapsed, DeltaTStale} {
+			labels := []metrics.Label{unit(u.UnitID), {Name: "state", Value: string(s)}}
+			states = append(states, metrics.Sample{Labels: labels, Value: metrics.Bool(u.State == s)})
+		}
+	}
+	metric("tstat_hvac_mode", "gauge", "Whether each HVAC unit is idle, cooling or heating, judged by its split", modes)
+	metric("tstat_hvac_delta_t_state", "gauge", "How each HVAC unit's split compares to its normal range", states)
+	metric("tstat_hvac_delta_t_abnormal", "gauge", "Whether each HVAC unit's split is outside its normal range", hvac(func(u DeltaTStatus) float64 {
+		return metrics.Bool(u.Abnormal())
+	}))
+}
@@ -0,0 +1,177 @@
+// Package monitor tracks messages received from real and simulated sensors
+package monitor
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Observation is a received message, decoded
+type Observation struct {
+	Time time.Time `json:"time"`
+	// From is the sender's address
+	From    string             `json:"from,omitempty"`
+	MAC     string             `json:"mac"`
+	Name    string             `json:"name"`
+	Type    sensor.SensorType  `json:"type"`
+	UnitID  int32              `json:"unitId"`
+	Message sensor.MessageType `json:"message"`
+	// Code is the raw temperature code, Temperature is it decoded to Fahrenheit
+	Code        int32                  `json:"code"`
+	Temperature float64                `json:"temperature"`
+	Battery     int32                  `json:"battery"`
+	PowerSource int32                  `json:"powerSource"`
+	Seq         int32                  `json:"seq"`
+	Signature   sensor.SignatureStatus `json:"signature"`
+	// Sent means this was sent by a simulated sensor rather than received
+	Sent bool `json:"sent,omitempty"`
+}
+
+// Observe decodes msg received from from at the given time
+func Observe(msg *sensor.SensorMsg, from net.Addr, sig sensor.SignatureStatus, at time.Time) Observation {
+	data := msg.GetDataWithHash().GetSensorData()
+	o := Observation{
+		Time:        at,
+		MAC:         data.GetMac(),
+		Name:        data.GetSensorName(),
+		Type:        data.GetSensorType(),
+		UnitID:      data.GetUnitId(),
+		Message:     msg.GetType(),
+		Code:        data.GetTemp(),
+		Temperature: sensor.CodeTemperature(data.GetTemp()).Value,
+		Battery:     data.GetBattery(),
+		PowerSource: data.GetPowerSource(),
+		Seq:         data.GetSeqNum(),
+		Signature:   sig,
+	}
+	if from != nil {
+		o.From = from.String()
+	}
+	return o
+}
+
+// Trusted is whether alerts and delta-T analysis should believe o. Anyone on
+// the network can send a bad signature or sign with a key from their own
+// pairing message, so those never are. With verified only readings signed with
+// a key from the key store are, otherwise readings from sensors without a
+// known key are too.
+func Trusted(o Observation, verified bool) bool {
+	switch o.Signature {
+	case sensor.SignatureValid:
+		return true
+	case sensor.SignatureNoKey:
+		return !verified
+	default:
+		return false
+	}
+}
+
+// Entry is everything tracked for one MAC
+type Entry struct {
+	Last  Observation
+	First time.Time
+	Count uint64
+	// History is the most recent observations, oldest first
+	History []Observation
+	// Battery is the battery level each time it changed since the battery
+	// was last replaced, oldest first
+	Battery []BatterySample
+}
+
+// Tracker keeps the latest observation and recent history for each MAC
+type Tracker struct {
+	// HistorySize is how many observations are kept per MAC, negative is
+	// treated as zero
+	HistorySize int
+
+	lock    sync.Mutex
+	entries map[string]*Entry
+}
+
+func NewTracker(historySize int) *Tracker {
+	return &Tracker{HistorySize: historySize, entries: make(map[string]*Entry)}
+}
+
+// Add records an observation
+func (t *Tracker) Add(o Observation) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e, ok := t.entries[o.MAC]
+	if !ok {
+		e = &Entry{First: o.Time}
+		t.entries[o.MAC] = e
+	}
+	e.Last = o
+	e.Count++
+	e.Battery = addBatterySample(e.Battery, o)
+	e.History = append(e.History, o)
+	if n := keep(t.HistorySize); len(e.History) > n {
+		e.History = append([]Observation(nil), e.History[len(e.History)-n:]...)
+	}
+}
+
+// keep is how much history a size keeps
+func keep(size int) int {
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// Entries returns a copy of every entry, sorted by MAC
+func (t *Tracker) Entries() []Entry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entries := make([]Entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		c := *e
+		c.History = append([]Observation(nil), e.History...)
+		c.Battery = append([]BatterySample(nil), e.Battery...)
+		entries = append(entries, c)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Last.MAC < entries[j].Last.MAC })
+	return entries
+}
+
+// Entry returns a copy of the entry for mac
+func (t *Tracker) Entry(mac string) (Entry, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e, ok := t.entries[mac]
+	if !ok {
+		return Entry{}, false
+	}
+	c := *e
+	c.History = append([]Observation(nil), e.History...)
+	c.Battery = append([]BatterySample(nil), e.Battery...)
+	return c, true
+}
+
+// CSVHeader is the column order of CSVRecord, new columns are only ever added
+// at the end
+var CSVHeader = []string{"time", "mac", "name", "type", "unit_id", "message", "code", "temperature_f", "battery", "power_source", "seq", "signature", "from", "sent"}
+
+// CSVRecord is the observation as CSV fields in CSVHeader order
+func (o Observation) CSVRecord() []string {
+	return []string{
+		o.Time.UTC().Format(time.RFC3339Nano),
+		o.MAC,
+		o.Name,
+		o.Type.String(),
+		strconv.Itoa(int(o.UnitID)),
+		o.Message.String(),
+		strconv.Itoa(int(o.Code)),
+		strconv.FormatFloat(o.Temperature, 'f', -1, 64),
+		strconv.Itoa(int(o.Battery)),
+		sensor.PowerSource(o.PowerSource).String(),
+		strconv.Itoa(int(o.Seq)),
+		o.Signature.String(),
+		o.From,
+		strconv.FormatBool(o.Sent),
+	}
+}
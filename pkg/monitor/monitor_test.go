@@ -0,0 +1,107 @@
+package monitor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func observe(t *testing.T, name string, temp float64, at time.Time) Observation {
+	msg, _, err := sensor.SimpleBuild(sensor.Temperature{Value: temp}, name, false, sensor.MAC{}, nil, sensor.SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	return Observe(msg, sensor.MemoryAddr("test"), sensor.SignatureValid, at)
+}
+
+func TestObserve(t *testing.T) {
+	now := time.Now()
+	o := observe(t, "Sensor1", 68, now)
+	assert.Equal(t, sensor.GenerateMAC("Sensor1").String(), o.MAC)
+	assert.Equal(t, "Sensor1", o.Name)
+	assert.Equal(t, int32(120), o.Code)
+	assert.Equal(t, 68.0, o.Temperature)
+	assert.Equal(t, sensor.MessageType_DATA, o.Message)
+	assert.Equal(t, int32(95), o.Battery)
+	assert.Equal(t, "test", o.From)
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker(2)
+	start := time.Now()
+	for i, temp := range []float64{70, 71, 72} {
+		tr.Add(observe(t, "A", temp, start.Add(time.Duration(i)*time.Minute)))
+	}
+	tr.Add(observe(t, "B", 60, start))
+
+	entries := tr.Entries()
+	assert.Len(t, entries, 2)
+	a, ok := tr.Entry(sensor.GenerateMAC("A").String())
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), a.Count)
+	assert.Equal(t, start, a.First)
+	assert.Len(t, a.History, 2, "History is limited")
+	assert.Equal(t, a.Last, a.History[1])
+
+	none := NewTracker(-1)
+	none.Add(observe(t, "A", 70, start))
+	a, _ = none.Entry(sensor.GenerateMAC("A").String())
+	assert.Empty(t, a.History, "Negative size keeps no history")
+
+	Sort(entries, ColumnByKey('t'), false)
+	assert.Equal(t, "B", entries[0].Last.Name)
+	Sort(entries, ColumnByKey('t'), true)
+	assert.Equal(t, "A", entries[0].Last.Name)
+	Sort(entries, ColumnByKey('a'), false)
+	assert.Equal(t, "A", entries[0].Last.Name, "Most recently seen first")
+
+	var b bytes.Buffer
+	RenderTable(&b, entries, start.Add(5*time.Minute), ColumnByKey('a'), false, 0, false)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\r\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "AGEv")
+	assert.Contains(t, lines[1], "71.6F")
+	assert.Contains(t, lines[1], "3m00s")
+	assert.Contains(t, lines[2], "5m00s")
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "0s", FormatAge(-time.Second))
+	assert.Equal(t, "59s", FormatAge(59*time.Second))
+	assert.Equal(t, "3m04s", FormatAge(3*time.Minute+4*time.Second))
+	assert.Equal(t, "2h05m", FormatAge(2*time.Hour+5*time.Minute))
+	assert.Equal(t, "3d01h", FormatAge(73*time.Hour))
+	assert.Equal(t, "20.0C", FormatTemperature(68, true))
+}
+
+func TestTrusted(t *testing.T) {
+	for sig, want := range map[sensor.SignatureStatus][2]bool{
+		sensor.SignatureValid:   {true, true},
+		sensor.SignatureNoKey:   {true, false},
+		sensor.SignatureInvalid: {false, false},
+		sensor.SignaturePairKey: {false, false},
+		sensor.SignaturePair:    {false, false},
+		sensor.SignatureError:   {false, false},
+	} {
+		o := Observation{Signature: sig}
+		assert.Equal(t, want[0], Trusted(o, false), "%s", sig)
+		assert.Equal(t, want[1], Trusted(o, true), "%s verified", sig)
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	assert.Equal(t, `Den\x1b]0;owned\a\r\n café`, Printable("Den\x1b]0;owned\a\r\n café"))
+
+	tracker := NewTracker(10)
+	now := time.Now()
+	tracker.Add(observe(t, "\x1b[2Jcafé", 70, now))
+	tracker.Add(observe(t, "Den", 70, now))
+	var b bytes.Buffer
+	RenderTable(&b, tracker.Entries(), now, 1, false, -1, false)
+	lines := strings.Split(b.String(), "\r\n")
+	assert.Contains(t, lines[1], `\x1b[2Jcafé  REMOTE`)
+	assert.Contains(t, lines[2], `Den          REMOTE`, "Columns are aligned by rune")
+	assert.Equal(t, 2, strings.Count(b.String(), "\x1b"), "Only the header's escape sequences reach the terminal")
+}
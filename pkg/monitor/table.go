@@ -0,0 +1,275 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Column is a column of the sensor table
+type Column struct {
+	Title string
+	// Key is the key that sorts by this column
+	Key   byte
+	value func(e Entry, now time.Time, celsius bool) string
+	less  func(a, b Entry) bool
+}
+
+// Columns are the sensor table's columns
+var Columns = []Column{
+	{"MAC", 'm', func(e Entry, now time.Time, celsius bool) string { return e.Last.MAC },
+		func(a, b Entry) bool { return a.Last.MAC < b.Last.MAC }},
+	{"NAME", 'n', func(e Entry, now time.Time, celsius bool) string { return Printable(e.Last.Name) },
+		func(a, b Entry) bool { return a.Last.Name < b.Last.Name }},
+	{"TYPE", 'y', func(e Entry, now time.Time, celsius bool) string { return e.Last.Type.String() },
+		func(a, b Entry) bool { return a.Last.Type < b.Last.Type }},
+	{"UNIT", 'u', func(e Entry, now time.Time, celsius bool) string { return fmt.Sprint(e.Last.UnitID) },
+		func(a, b Entry) bool { return a.Last.UnitID < b.Last.UnitID }},
+	{"TEMP", 't', func(e Entry, now time.Time, celsius bool) string {
+		return FormatTemperature(e.Last.Temperature, celsius)
+	},
+		func(a, b Entry) bool { return a.Last.Code < b.Last.Code }},
+	{"BATT", 'b', func(e Entry, now time.Time, celsius bool) string { return fmt.Sprintf("%d%%", e.Last.Battery) },
+		func(a, b Entry) bool { return a.Last.Battery < b.Last.Battery }},
+	{"BATT LEFT", 'r', func(e Entry, now time.Time, celsius bool) string {
+		est, ok := e.BatteryEstimate()
+		if !ok {
+			return "-"
+		}
+		return FormatAge(est.Empty.Sub(now))
+	},
+		func(a, b Entry) bool {
+			ea, oka := a.BatteryEstimate()
+			eb, okb := b.BatteryEstimate()
+			// Unknown estimates sort last
+			return oka && (!okb || ea.Empty.Before(eb.Empty))
+		}},
+	{"POWER", 'p', func(e Entry, now time.Time, celsius bool) string {
+		return sensor.PowerSource(e.Last.PowerSource).String()
+	},
+		func(a, b Entry) bool { return a.Last.PowerSource < b.Last.PowerSource }},
+	{"SEQ", 'e', func(e Entry, now time.Time, celsius bool) string { return fmt.Sprint(e.Last.Seq) },
+		func(a, b Entry) bool { return a.Last.Seq < b.Last.Seq }},
+	{"AGE", 'a', func(e Entry, now time.Time, celsius bool) string { return FormatAge(now.Sub(e.Last.Time)) },
+		func(a, b Entry) bool { return a.Last.Time.After(b.Last.Time) }},
+	{"SIGNATURE", 's', func(e Entry, now time.Time, celsius bool) string { return e.Last.Signature.String() },
+		func(a, b Entry) bool { return a.Last.Signature < b.Last.Signature }},
+	{"COUNT", 'c', func(e Entry, now time.Time, celsius bool) string { return fmt.Sprint(e.Count) },
+		func(a, b Entry) bool { return a.Count < b.Count }},
+}
+
+// ColumnByKey returns the index of the column sorted by key, or -1
+func ColumnByKey(key byte) int {
+	for i, c := range Columns {
+		if c.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Sort sorts entries by the column, ties are broken by MAC
+func Sort(entries []Entry, column int, reverse bool) {
+	less := Columns[column].less
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if reverse {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return entries[i].Last.MAC < entries[j].Last.MAC
+	})
+}
+
+// Printable escapes control characters and other non-printable runes, like
+// strconv.Quote does, so text from the network can't send escape sequences to
+// the terminal
+func Printable(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsPrint(r) {
+			b.WriteRune(r)
+			continue
+		}
+		q := strconv.QuoteRune(r)
+		b.WriteString(q[1 : len(q)-1])
+	}
+	return b.String()
+}
+
+// FormatTemperature formats a Fahrenheit value, converting it if celsius is set
+func FormatTemperature(f float64, celsius bool) string {
+	t := sensor.Temperature{Value: f}.In(celsius)
+	unit := "F"
+	if celsius {
+		unit = "C"
+	}
+	return fmt.Sprintf("%.1f%s", t.Value, unit)
+}
+
+// FormatAge formats a duration to about two significant units, e.g. 3m04s
+func FormatAge(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%02dh", int(d.Hours())/24, int(d.Hours())%24)
+	}
+}
+
+// RenderTable writes entries as a table. The sort column's title is marked and
+// the selected row (-1 for none) is highlighted with ANSI reverse video.
+func RenderTable(w io.Writer, entries []Entry, now time.Time, sortColumn int, reverse bool, selected int, celsius bool) {
+	rows := make([][]string, len(entries)+1)
+	rows[0] = make([]string, len(Columns))
+	for i, c := range Columns {
+		title := c.Title
+		if i == sortColumn {
+			if reverse {
+				title += "^"
+			} else {
+				title += "v"
+			}
+		}
+		rows[0][i] = title
+	}
+	for r, e := range entries {
+		row := make([]string, len(Columns))
+		for i, c := range Columns {
+			row[i] = c.value(e, now, celsius)
+		}
+		rows[r+1] = row
+	}
+	for r, line := range align(rows) {
+		switch {
+		case r == 0:
+			fmt.Fprintf(w, "\x1b[1m%s\x1b[0m\r\n", line)
+		case r-1 == selected:
+			fmt.Fprintf(w, "\x1b[7m%s\x1b[0m\r\n", line)
+		default:
+			fmt.Fprintf(w, "%s\r\n", line)
+		}
+	}
+}
+
+// RenderHistory writes an entry's history as a table, newest first
+func RenderHistory(w io.Writer, e Entry, now time.Time, celsius bool) {
+	fmt.Fprintf(w, "\x1b[1m%s (%s) %s unit %d, %d messages since %s\x1b[0m\r\n\r\n",
+		Printable(e.Last.Name), e.Last.MAC, e.Last.Type, e.Last.UnitID, e.Count, e.First.Format("2006-01-02 15:04:05"))
+	if est, ok := e.BatteryEstimate(); ok {
+		fmt.Fprintf(w, "Battery %d%%, draining %.2f%% a day, empty around %s\r\n\r\n",
+			e.Last.Battery, est.PerDay, est.Empty.Format("2006-01-02"))
+	}
+	rows := [][]string{{"TIME", "AGE", "MESSAGE", "TEMP", "CODE", "BATT", "SEQ", "SIGNATURE", "FROM"}}
+	for i := len(e.History) - 1; i >= 0; i-- {
+		o := e.History[i]
+		rows = append(rows, []string{
+			o.Time.Format("15:04:05"),
+			FormatAge(now.Sub(o.Time)),
+			o.Message.String(),
+			FormatTemperature(o.Temperature, celsius),
+			fmt.Sprint(o.Code),
+			fmt.Sprintf("%d%%", o.Battery),
+			fmt.Sprint(o.Seq),
+			o.Signature.String(),
+			Printable(o.From),
+		})
+	}
+	for r, line := range align(rows) {
+		if r == 0 {
+			fmt.Fprintf(w, "\x1b[1m%s\x1b[0m\r\n", line)
+		} else {
+			fmt.Fprintf(w, "%s\r\n", line)
+		}
+	}
+}
+
+// FormatDelta formats a Fahrenheit temperature difference, converting it if
+// celsius is set
+func FormatDelta(f float64, celsius bool) string {
+	if celsius {
+		return fmt.Sprintf("%.1fC", f/1.8)
+	}
+	return fmt.Sprintf("%.1fF", f)
+}
+
+// RenderDeltaT writes the supply/return split of each HVAC unit as a table,
+// abnormal splits are shown in red
+func RenderDeltaT(w io.Writer, units []DeltaTStatus, now time.Time, celsius bool) {
+	rows := [][]string{{"UNIT", "MODE", "SUPPLY", "RETURN", "DELTA-T", "FOR", "STATE"}}
+	for _, u := range units {
+		state := string(u.State)
+		if u.Message != "" {
+			state += ": " + u.Message
+		}
+		rows = append(rows, []string{
+			fmt.Sprint(u.UnitID),
+			string(u.Mode),
+			FormatTemperature(u.Supply, celsius),
+			FormatTemperature(u.Return, celsius),
+			FormatDelta(u.DeltaT(), celsius),
+			FormatAge(now.Sub(u.Since)),
+			state,
+		})
+	}
+	for r, line := range align(rows) {
+		switch {
+		case r == 0:
+			fmt.Fprintf(w, "\x1b[1m%s\x1b[0m\r\n", line)
+		case units[r-1].Abnormal():
+			fmt.Fprintf(w, "\x1b[31m%s\x1b[0m\r\n", line)
+		default:
+			fmt.Fprintf(w, "%s\r\n", line)
+		}
+	}
+}
+
+// align pads each column to its widest cell
+func align(rows [][]string) []string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	lines := make([]string, len(rows))
+	for r, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			if i == len(row)-1 {
+				b.WriteString(cell)
+			} else {
+				b.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		lines[r] = b.String()
+	}
+	return lines
+}
@@ -0,0 +1,426 @@
+// Package mqtt is a minimal MQTT 3.1.1 client that can only publish at QoS 0,
+// which is all that's needed to feed readings to a broker
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeepAlive is how often the client pings an otherwise idle broker
+const DefaultKeepAlive = 60 * time.Second
+
+// DefaultWriteTimeout bounds each write to the broker
+const DefaultWriteTimeout = 10 * time.Second
+
+// ErrClosed is returned when publishing on a closed client
+var ErrClosed = errors.New("mqtt client closed")
+
+const (
+	typeConnect    = 1
+	typeConnAck    = 2
+	typePublish    = 3
+	typePingReq    = 12
+	typePingResp   = 13
+	typeDisconnect = 14
+)
+
+var connectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Message is a message to publish
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Options configure a connection
+type Options struct {
+	// URL is the broker, mqtt://host[:1883] or mqtts://host[:8883] for TLS, with
+	// optional user:password@ credentials
+	URL       string
+	ClientID  string
+	KeepAlive time.Duration
+	// WriteTimeout bounds each write once connected, default DefaultWriteTimeout.
+	// A write that times out closes the connection.
+	WriteTimeout time.Duration
+	// Will is published by the broker if the connection is lost
+	Will *Message
+	TLS  *tls.Config
+}
+
+// Client is a connection to a broker
+type Client struct {
+	lock   sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	closed bool
+	done   chan struct{}
+	err    error
+	// writeTimeout is zero while connecting, the context's deadline applies
+	writeTimeout time.Duration
+}
+
+// ParseURL parses and checks a broker URL, see Options. Errors leave out the
+// password.
+func ParseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error includes the whole URL and its reason can quote part of
+		// the password
+		if strings.Contains(raw, "@") {
+			return nil, fmt.Errorf("invalid mqtt url [%s]", redact(raw))
+		}
+		return nil, fmt.Errorf("invalid mqtt url: %w", err)
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp", "mqtts", "ssl", "tls":
+	default:
+		return nil, fmt.Errorf("invalid mqtt url [%s], use mqtt:// or mqtts://", u.Redacted())
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid mqtt url [%s], no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// redact hides the credentials of a URL that can't be parsed
+func redact(raw string) string {
+	at := strings.LastIndex(raw, "@")
+	if at < 0 {
+		return raw
+	}
+	start := strings.Index(raw, "://")
+	if start < 0 || start > at {
+		start = 0
+	} else {
+		start += len("://")
+	}
+	return raw[:start] + "xxxxx" + raw[at:]
+}
+
+// Connect dials the broker and completes the MQTT handshake
+func Connect(ctx context.Context, opts Options) (*Client, error) {
+	u, err := ParseURL(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	d := &net.Dialer{}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "mqtts", "ssl", "tls":
+		config := opts.TLS
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		var raw net.Conn
+		raw, err = d.DialContext(ctx, "tcp", hostPort(u, "8883"))
+		if err == nil {
+			tlsConn := tls.Client(raw, config)
+			err = tlsConn.HandshakeContext(ctx)
+			if err != nil {
+				raw.Close()
+			}
+			conn = tlsConn
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to [%s]: %w", u.Host, err)
+	}
+
+	keepAlive := opts.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	c := &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), done: make(chan struct{})}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := abortOnCancel(ctx, conn)
+	err = c.write(typeConnect<<4, connectPacket(u, opts, keepAlive))
+	if err == nil {
+		err = c.readConnAck()
+	}
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("error connecting to [%s]: %w", u.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	c.writeTimeout = opts.WriteTimeout
+	if c.writeTimeout == 0 {
+		c.writeTimeout = DefaultWriteTimeout
+	}
+
+	// Pings are sent every half keep alive, the broker has gone quiet if
+	// there's no answer to a couple of them
+	go c.read(keepAlive * 3 / 2)
+	go c.ping(keepAlive)
+	return c, nil
+}
+
+// abortOnCancel fails blocked reads and writes on conn if ctx is done before
+// stop is called
+func abortOnCancel(ctx context.Context, conn net.Conn) (stop func()) {
+	var lock sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			lock.Lock()
+			defer lock.Unlock()
+			select {
+			case <-done:
+			default:
+				conn.SetDeadline(time.Unix(1, 0))
+			}
+		case <-done:
+		}
+	}()
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+		close(done)
+	}
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func connectPacket(u *url.URL, opts Options, keepAlive time.Duration) []byte {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendBytes(payload, opts.Will.Payload)
+	}
+	if u.User != nil {
+		flags |= 0x80
+		payload = appendString(payload, u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			flags |= 0x40
+			payload = appendString(payload, password)
+		}
+	}
+	secs := int(keepAlive / time.Second)
+	p := appendString(nil, "MQTT")
+	p = append(p, 4, flags, byte(secs>>8), byte(secs))
+	return append(p, payload...)
+}
+
+func (c *Client) readConnAck() error {
+	header, body, err := readPacket(c.r)
+	if err != nil {
+		return err
+	}
+	if header>>4 != typeConnAck || len(body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type [%d]", header>>4)
+	}
+	if body[1] != 0 {
+		reason, ok := connectErrors[body[1]]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", body[1])
+		}
+		return fmt.Errorf("connection refused: %s", reason)
+	}
+	return nil
+}
+
+// read consumes packets from the broker until the connection drops or nothing
+// arrives for timeout
+func (c *Client) read(timeout time.Duration) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		// Nothing is subscribed, so only PINGRESP is expected
+		_, _, err := readPacket(c.r)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				err = fmt.Errorf("no response from broker for %s", timeout)
+			}
+			c.fail(err)
+			return
+		}
+	}
+}
+
+func (c *Client) ping(keepAlive time.Duration) {
+	ticker := time.NewTicker(keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			err := c.write(typePingReq<<4, nil)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail closes the connection after an error, later calls return it
+func (c *Client) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failLocked(err)
+}
+
+func (c *Client) failLocked(err error) {
+	if c.closed {
+		return
+	}
+	if err == io.EOF {
+		err = errors.New("connection closed by broker")
+	}
+	c.err = err
+	c.closed = true
+	close(c.done)
+	c.conn.Close()
+}
+
+// Err is why the connection was lost, nil while it's up
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed && c.err == nil {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// Publish sends a message at QoS 0
+func (c *Client) Publish(m Message) error {
+	var header byte = typePublish << 4
+	if m.Retain {
+		header |= 0x01
+	}
+	return c.write(header, append(appendString(nil, m.Topic), m.Payload...))
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		if c.err != nil {
+			return c.err
+		}
+		return ErrClosed
+	}
+	if len(body) > maxLength {
+		return fmt.Errorf("packet too large [%d]", len(body))
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	c.w.WriteByte(header)
+	c.w.Write(appendLength(nil, len(body)))
+	c.w.Write(body)
+	err := c.w.Flush()
+	if err != nil && c.writeTimeout > 0 {
+		// A partly written packet leaves the stream unusable
+		c.failLocked(err)
+	}
+	return err
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	err := c.write(typeDisconnect<<4, nil)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+		c.conn.Close()
+	}
+	return err
+}
+
+// maxLength is the largest remaining length MQTT can encode
+const maxLength = 268435455
+
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = append(b, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(digit&0x7f) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,181 @@
+// Package schedule picks which inputs feed a sensor, and how much each counts,
+// by day of week and time of day.
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a set of weighted inputs used during a window of time
+type Entry struct {
+	Name string `json:"name,omitempty"`
+	// Days the entry starts on: mon, tue, wed, thu, fri, sat, sun, weekdays or
+	// weekends. Empty means every day.
+	Days []string `json:"days,omitempty"`
+	// From and To are local wall clock times like "22:00". A window that ends
+	// before it starts runs past midnight into the next day. Both empty means
+	// all day.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Weights are the relative weights of each input or group
+	Weights map[string]float64 `json:"weights"`
+
+	days     map[time.Weekday]bool
+	from, to time.Duration
+}
+
+// Schedule is a list of entries, the first one matching a time is used
+type Schedule []Entry
+
+var dayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// Compile validates every entry and prepares it for matching, it must be called
+// before Active
+func (s Schedule) Compile() error {
+	if len(s) == 0 {
+		return fmt.Errorf("schedule has no entries")
+	}
+	for i := range s {
+		e := &s[i]
+		name := e.Label(i)
+		e.days = make(map[time.Weekday]bool)
+		for _, d := range e.Days {
+			days, ok := dayNames[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("schedule entry [%s]: invalid day [%s]", name, d)
+			}
+			for _, day := range days {
+				e.days[day] = true
+			}
+		}
+		if (e.From == "") != (e.To == "") {
+			return fmt.Errorf("schedule entry [%s]: needs both from and to", name)
+		}
+		if e.From != "" {
+			var err error
+			e.from, err = parseClock(e.From)
+			if err != nil {
+				return fmt.Errorf("schedule entry [%s]: %w", name, err)
+			}
+			e.to, err = parseClock(e.To)
+			if err != nil {
+				return fmt.Errorf("schedule entry [%s]: %w", name, err)
+			}
+			if e.from == e.to {
+				return fmt.Errorf("schedule entry [%s]: from and to are both [%s], leave both empty for all day", name, e.From)
+			}
+		}
+		if len(e.Weights) == 0 {
+			return fmt.Errorf("schedule entry [%s]: needs at least one weight", name)
+		}
+		total := 0.0
+		for input, w := range e.Weights {
+			if w < 0 {
+				return fmt.Errorf("schedule entry [%s]: negative weight for [%s]", name, input)
+			}
+			total += w
+		}
+		if total == 0 {
+			return fmt.Errorf("schedule entry [%s]: weights are all zero", name)
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time [%s], use HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Label is the entry's name, or its position if it doesn't have one
+func (e *Entry) Label(i int) string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Inputs returns the names the entry weights, sorted
+func (e *Entry) Inputs() []string {
+	r := make([]string, 0, len(e.Weights))
+	for name := range e.Weights {
+		r = append(r, name)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// matches reports whether t falls in the entry's window
+func (e *Entry) matches(t time.Time) bool {
+	if e.From == "" {
+		return e.dayMatches(t.Weekday())
+	}
+	// Wall clock time, not time since midnight, which is off by an hour after
+	// a daylight saving change
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if e.from <= e.to {
+		return clock >= e.from && clock < e.to && e.dayMatches(t.Weekday())
+	}
+	// Runs past midnight, the early part belongs to the previous day's window
+	if clock >= e.from {
+		return e.dayMatches(t.Weekday())
+	}
+	if clock < e.to {
+		return e.dayMatches((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (e *Entry) dayMatches(d time.Weekday) bool {
+	return len(e.days) == 0 || e.days[d]
+}
+
+// Active returns the index of the first entry matching t, or -1 if none do
+func (s Schedule) Active(t time.Time) int {
+	for i := range s {
+		if s[i].matches(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// WeightedMean averages values by the weights of the entry active at t, names
+// missing from values are left out. ok is false if no entry is active or none of
+// its inputs have a value.
+func (s Schedule) WeightedMean(t time.Time, values map[string]float64) (float64, int, bool) {
+	i := s.Active(t)
+	if i < 0 {
+		return 0, i, false
+	}
+	sum, total := 0.0, 0.0
+	for name, w := range s[i].Weights {
+		v, ok := values[name]
+		if !ok || w == 0 {
+			continue
+		}
+		sum += v * w
+		total += w
+	}
+	if total == 0 {
+		return 0, i, false
+	}
+	return sum / total, i, true
+}
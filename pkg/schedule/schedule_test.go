@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, s string) Schedule {
+	var sched Schedule
+	assert.NoError(t, json.Unmarshal([]byte(s), &sched))
+	return sched
+}
+
+func TestActive(t *testing.T) {
+	s := parse(t, `[
+		{"name": "weeknight", "days": ["weekdays"], "from": "22:00", "to": "07:00", "weights": {"bedrooms": 1}},
+		{"name": "weekend night", "days": ["sat", "sun"], "from": "23:30", "to": "09:00", "weights": {"bedrooms": 1}},
+		{"name": "day", "weights": {"living": 2, "kitchen": 1}}
+	]`)
+	assert.NoError(t, s.Compile())
+
+	// 2022-05-02 is a Monday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2022, 5, day, hour, minute, 0, 0, time.Local)
+	}
+	test := func(when time.Time, expected string) {
+		t.Run(when.Format("Mon 15:04"), func(t *testing.T) {
+			i := s.Active(when)
+			assert.True(t, i >= 0)
+			assert.Equal(t, expected, s[i].Label(i))
+		})
+	}
+	test(at(2, 12, 0), "day")
+	test(at(2, 22, 0), "weeknight")
+	test(at(3, 6, 59), "weeknight")
+	test(at(3, 7, 0), "day")
+	test(at(2, 3, 0), "weekend night") // Sunday night into Monday
+	test(at(7, 3, 0), "weeknight")     // Friday night into Saturday
+	test(at(7, 23, 0), "day")
+	test(at(7, 23, 30), "weekend night")
+	test(at(8, 8, 59), "weekend night")
+	test(at(9, 8, 0), "weekend night")
+	test(at(9, 9, 0), "day")
+}
+
+func TestActiveDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("No time zone data")
+	}
+	s := parse(t, `[
+		{"name": "morning", "from": "06:00", "to": "09:00", "weights": {"bedrooms": 1}},
+		{"name": "day", "weights": {"living": 1}}
+	]`)
+	assert.NoError(t, s.Compile())
+
+	// Clocks change at 2am on both days, the windows follow the wall clock
+	for _, day := range []int{13, 6} {
+		month := time.March
+		if day == 6 {
+			month = time.November
+		}
+		at := func(hour, minute int) time.Time {
+			return time.Date(2022, month, day, hour, minute, 0, 0, loc)
+		}
+		assert.Equal(t, 1, s.Active(at(5, 59)), "%s %d 05:59", month, day)
+		assert.Equal(t, 0, s.Active(at(6, 0)), "%s %d 06:00", month, day)
+		assert.Equal(t, 0, s.Active(at(8, 59)), "%s %d 08:59", month, day)
+		assert.Equal(t, 1, s.Active(at(9, 0)), "%s %d 09:00", month, day)
+	}
+}
+
+func TestWeightedMean(t *testing.T) {
+	s := parse(t, `[
+		{"from": "00:00", "to": "12:00", "weights": {"living": 2, "kitchen": 1, "office": 0}},
+		{"days": ["mon"], "weights": {"missing": 1}}
+	]`)
+	assert.NoError(t, s.Compile())
+	values := map[string]float64{"living": 70, "kitchen": 73, "office": 100}
+
+	v, i, ok := s.WeightedMean(time.Date(2022, 5, 2, 8, 0, 0, 0, time.Local), values)
+	assert.True(t, ok)
+	assert.Equal(t, 0, i)
+	assert.Equal(t, 71.0, v)
+
+	delete(values, "living")
+	v, _, ok = s.WeightedMean(time.Date(2022, 5, 2, 8, 0, 0, 0, time.Local), values)
+	assert.True(t, ok)
+	assert.Equal(t, 73.0, v, "Missing inputs are left out")
+
+	_, i, ok = s.WeightedMean(time.Date(2022, 5, 2, 13, 0, 0, 0, time.Local), values)
+	assert.False(t, ok, "No values for the active entry")
+	assert.Equal(t, 1, i)
+
+	_, i, ok = s.WeightedMean(time.Date(2022, 5, 3, 13, 0, 0, 0, time.Local), values)
+	assert.False(t, ok, "No entry active")
+	assert.Equal(t, -1, i)
+}
+
+func TestCompileErrors(t *testing.T) {
+	for name, bad := range map[string]string{
+		"empty":       `[]`,
+		"bad day":     `[{"days": ["someday"], "weights": {"a": 1}}]`,
+		"half window": `[{"from": "10:00", "weights": {"a": 1}}]`,
+		"bad time":    `[{"from": "25:00", "to": "10:00", "weights": {"a": 1}}]`,
+		"same time":   `[{"from": "10:00", "to": "10:00", "weights": {"a": 1}}]`,
+		"no weights":  `[{}]`,
+		"negative":    `[{"weights": {"a": -1}}]`,
+		"all zero":    `[{"weights": {"a": 0}}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, parse(t, bad).Compile())
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package sensor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The range the thermostat accepts. Codes outside it are rejected or shown wrong.
+const (
+	MinFahrenheit = -40.0
+	MaxFahrenheit = 140.0
+	MinCode       = 0
+	MaxCode       = 200
+)
+
+// ErrOutOfRange is wrapped by Encode errors for temperatures the thermostat can't show
+var ErrOutOfRange = errors.New("temperature out of range")
+
+// Rounding is how a temperature is rounded to a wire code
+type Rounding int
+
+const (
+	RoundNearest Rounding = iota
+	RoundFloor
+	RoundCeil
+)
+
+var roundingNames = []string{"nearest", "floor", "ceil"}
+
+func (r Rounding) String() string {
+	if r < 0 || int(r) >= len(roundingNames) {
+		return fmt.Sprintf("Rounding(%d)", int(r))
+	}
+	return roundingNames[r]
+}
+
+// ParseRounding parses a rounding mode name (nearest, floor, ceil), blank is nearest
+func ParseRounding(s string) (Rounding, error) {
+	if s == "" {
+		return RoundNearest, nil
+	}
+	for i, name := range roundingNames {
+		if strings.EqualFold(s, name) {
+			return Rounding(i), nil
+		}
+	}
+	return RoundNearest, fmt.Errorf("invalid rounding mode [%s]", s)
+}
+
+func (r Rounding) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rounding) UnmarshalText(text []byte) error {
+	v, err := ParseRounding(string(text))
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// round applies the rounding mode to an unrounded code
+func (r Rounding) round(raw float64) float64 {
+	switch r {
+	case RoundFloor:
+		return math.Floor(raw)
+	case RoundCeil:
+		return math.Ceil(raw)
+	default:
+		return math.Round(raw)
+	}
+}
+
+// bounds are the unrounded codes that round to code
+func (r Rounding) bounds(code float64) (float64, float64) {
+	switch r {
+	case RoundFloor:
+		return code, code + 1
+	case RoundCeil:
+		return code - 1, code
+	default:
+		return code - 0.5, code + 0.5
+	}
+}
+
+// CodeStep is how many degrees Fahrenheit one wire code covers
+const CodeStep = 0.9
+
+// RawCode is the unrounded wire code for t
+func RawCode(t Temperature) float64 {
+	// Determined experimentally
+	return (t.Fahrenheit() + 40) / CodeStep
+}
+
+// CodeTemperature converts a wire code back to degrees Fahrenheit, rounded to
+// hundredths to hide floating point noise
+func CodeTemperature(code int32) Temperature {
+	return Temperature{Value: math.Round((float64(code)*CodeStep-40)*100) / 100}
+}
+
+// Encode rounds t to a wire code, refusing NaN, infinities and temperatures
+// outside MinFahrenheit to MaxFahrenheit
+func Encode(t Temperature, mode Rounding) (int32, error) {
+	return encode(t, mode, false)
+}
+
+// EncodeClamped is Encode but out of range temperatures are clamped to the
+// nearest limit with a warning. NaN is still refused.
+func EncodeClamped(t Temperature, mode Rounding) (int32, error) {
+	return encode(t, mode, true)
+}
+
+func encode(t Temperature, mode Rounding, clamp bool) (int32, error) {
+	f, err := checkRange(t, clamp)
+	if err != nil {
+		return 0, err
+	}
+	return *Temperature{Value: f}.Round(mode), nil
+}
+
+// checkRange returns t in Fahrenheit, clamped if clamp is set
+func checkRange(t Temperature, clamp bool) (float64, error) {
+	f := t.Fahrenheit()
+	if math.IsNaN(f) {
+		return 0, fmt.Errorf("%w: not a number", ErrOutOfRange)
+	}
+	if f >= MinFahrenheit && f <= MaxFahrenheit {
+		return f, nil
+	}
+	if !clamp || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%w: %vF is outside %vF to %vF", ErrOutOfRange, f, MinFahrenheit, MaxFahrenheit)
+	}
+	clamped := math.Min(math.Max(f, MinFahrenheit), MaxFahrenheit)
+	log.Warn().Float64("tempF", f).Float64("clamped", clamped).Msg("Temperature out of range, clamping")
+	return clamped, nil
+}
+
+// Encoder turns a series of temperatures into wire codes. A value hovering on a
+// rounding boundary would otherwise flip the code on every send, so the last
+// code is held until the value moves DeadBand degrees past its boundary.
+type Encoder struct {
+	Rounding Rounding
+	// DeadBand is in degrees Fahrenheit, zero disables hysteresis
+	DeadBand float64
+	// Clamp clamps out of range temperatures instead of returning an error
+	Clamp bool
+
+	last   int32
+	primed bool
+}
+
+// Encode returns the code to send for t, range checked like Encode
+func (e *Encoder) Encode(t Temperature) (int32, error) {
+	f, err := checkRange(t, e.Clamp)
+	if err != nil {
+		return 0, err
+	}
+	raw := RawCode(Temperature{Value: f})
+	if e.primed {
+		lo, hi := e.Rounding.bounds(float64(e.last))
+		margin := e.DeadBand / CodeStep
+		if raw >= lo-margin && raw <= hi+margin {
+			return e.last, nil
+		}
+	}
+	e.last = int32(e.Rounding.round(raw))
+	e.primed = true
+	return e.last, nil
+}
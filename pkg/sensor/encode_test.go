@@ -0,0 +1,96 @@
+package sensor
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tempAt is the Fahrenheit temperature for an unrounded code
+func tempAt(raw float64) Temperature {
+	return Temperature{Value: raw*CodeStep - 40}
+}
+
+func TestRounding(t *testing.T) {
+	for mode, expected := range map[Rounding]int32{RoundNearest: 121, RoundFloor: 120, RoundCeil: 121} {
+		assert.Equal(t, expected, *tempAt(120.6).Round(mode), mode.String())
+	}
+	var r Rounding
+	assert.NoError(t, json.Unmarshal([]byte(`"floor"`), &r))
+	assert.Equal(t, RoundFloor, r)
+	assert.Error(t, json.Unmarshal([]byte(`"up"`), &r))
+	assert.Equal(t, 68.0, CodeTemperature(120).Value)
+}
+
+// encodeOK is Encoder.Encode for values known to be in range
+func encodeOK(t *testing.T, e *Encoder, temp Temperature) int32 {
+	code, err := e.Encode(temp)
+	assert.NoError(t, err)
+	return code
+}
+
+func TestEncoder(t *testing.T) {
+	e := &Encoder{DeadBand: 0.18} // 0.2 of a code
+	for i, c := range []struct {
+		raw  float64
+		code int32
+	}{
+		{120.45, 120},
+		{120.55, 120}, // Past the boundary but inside the dead band
+		{120.65, 120},
+		{120.75, 121},
+		{120.35, 121},
+		{120.25, 120},
+		{118.0, 118},
+	} {
+		assert.Equal(t, c.code, encodeOK(t, e, tempAt(c.raw)), "reading %d", i)
+	}
+
+	e = &Encoder{}
+	assert.Equal(t, int32(120), encodeOK(t, e, tempAt(120.49)))
+	assert.Equal(t, int32(121), encodeOK(t, e, tempAt(120.51)), "No dead band follows rounding exactly")
+
+	e = &Encoder{Rounding: RoundFloor, DeadBand: 0.18}
+	assert.Equal(t, int32(120), encodeOK(t, e, tempAt(120.9)))
+	assert.Equal(t, int32(120), encodeOK(t, e, tempAt(121.1)))
+	assert.Equal(t, int32(121), encodeOK(t, e, tempAt(121.3)))
+	assert.Equal(t, int32(121), encodeOK(t, e, tempAt(120.9)))
+}
+
+func TestEncode(t *testing.T) {
+	for _, f := range []float64{-40, 0, 68, 140} {
+		_, err := Encode(Temperature{Value: f}, RoundNearest)
+		assert.NoError(t, err, "%vF", f)
+	}
+	code, err := Encode(Temperature{Value: 60, Celsius: true}, RoundNearest)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(200), code, "60C is 140F")
+
+	for _, f := range []float64{-60, -40.1, 140.1, 1e12, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := Encode(Temperature{Value: f}, RoundNearest)
+		assert.True(t, errors.Is(err, ErrOutOfRange), "%vF", f)
+	}
+
+	code, err = EncodeClamped(Temperature{Value: -60}, RoundNearest)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(MinCode), code)
+	code, err = EncodeClamped(Temperature{Value: 1e12}, RoundCeil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(MaxCode), code)
+	_, err = EncodeClamped(Temperature{Value: math.NaN()}, RoundNearest)
+	assert.Error(t, err)
+	_, err = EncodeClamped(Temperature{Value: math.Inf(1)}, RoundNearest)
+	assert.Error(t, err)
+
+	e := &Encoder{}
+	_, err = e.Encode(Temperature{Value: 150})
+	assert.Error(t, err)
+	e.Clamp = true
+	assert.Equal(t, int32(MaxCode), encodeOK(t, e, Temperature{Value: 150}))
+
+	_, _, err = SimpleBuild(Temperature{Value: -60}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.True(t, errors.Is(err, ErrOutOfRange))
+}
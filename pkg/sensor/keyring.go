@@ -0,0 +1,135 @@
+package sensor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SignatureStatus is the result of checking a received message's signature
+type SignatureStatus int
+
+const (
+	// SignatureNoKey means no key is known for the sensor yet
+	SignatureNoKey SignatureStatus = iota
+	SignatureValid
+	SignatureInvalid
+	// SignaturePair means the message is a pairing message, it carries a key
+	// rather than a signature
+	SignaturePair
+	// SignatureError means the message couldn't be checked, e.g. a bad hash
+	SignatureError
+	// SignaturePairKey means the signature checks out against a key from a
+	// pairing message seen on the wire rather than the key store. Anyone on the
+	// network can send a pairing message, so it's no proof of who sent it.
+	SignaturePairKey
+)
+
+var signatureNames = []string{"no-key", "valid", "invalid", "pair", "error", "pair-key"}
+
+func (s SignatureStatus) String() string {
+	if s < 0 || int(s) >= len(signatureNames) {
+		return fmt.Sprintf("SignatureStatus(%d)", int(s))
+	}
+	return signatureNames[s]
+}
+
+// ParseSignatureStatus parses a status name (no-key, valid, invalid, pair, error,
+// pair-key)
+func ParseSignatureStatus(s string) (SignatureStatus, error) {
+	for i, name := range signatureNames {
+		if strings.EqualFold(s, name) {
+			return SignatureStatus(i), nil
+		}
+	}
+	return SignatureNoKey, fmt.Errorf("invalid signature status [%s]", s)
+}
+
+func (s SignatureStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *SignatureStatus) UnmarshalText(text []byte) error {
+	v, err := ParseSignatureStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// KeyRing holds sensor keys by wire MAC for checking received messages. Keys
+// are loaded from a KeyStore, or learned from pairing messages as they're seen.
+// A pairing message never replaces a key from the store.
+type KeyRing struct {
+	lock sync.Mutex
+	keys map[string]ringKey
+}
+
+type ringKey struct {
+	key []byte
+	// stored is whether the key came from the key store
+	stored bool
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]ringKey)}
+}
+
+// Add sets the key for a wire MAC as if it came from a pairing message, unless
+// the MAC has a key from the key store
+func (k *KeyRing) Add(mac string, key []byte) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.keys[mac].stored {
+		return
+	}
+	k.keys[mac] = ringKey{key: key}
+}
+
+// AddStore adds the key of every identity in store
+func (k *KeyRing) AddStore(store *KeyStore) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for _, id := range store.List() {
+		k.keys[id.WireMAC()] = ringKey{key: id.Key, stored: true}
+	}
+}
+
+// Key returns the key for a wire MAC
+func (k *KeyRing) Key(mac string) ([]byte, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	key, ok := k.keys[mac]
+	return key.key, ok
+}
+
+// Check validates msg's signature. Pairing messages add their key to the ring.
+// Messages signed with a key from the store are SignatureValid, ones signed
+// with a key from a pairing message are SignaturePairKey. The error explains
+// SignatureInvalid and SignatureError results.
+func (k *KeyRing) Check(msg *SensorMsg) (SignatureStatus, error) {
+	mac := msg.GetDataWithHash().GetSensorData().GetMac()
+	if msg.GetType() == MessageType_PAIR {
+		key, err := GetHashBytes(msg)
+		if err != nil {
+			return SignatureError, fmt.Errorf("error decoding hash: %w", err)
+		}
+		k.Add(mac, key)
+		return SignaturePair, nil
+	}
+	k.lock.Lock()
+	key, ok := k.keys[mac]
+	k.lock.Unlock()
+	if !ok {
+		return SignatureNoKey, nil
+	}
+	err := ValidateSignature(msg, key.key)
+	if err != nil {
+		return SignatureInvalid, err
+	}
+	if !key.stored {
+		return SignaturePairKey, nil
+	}
+	return SignatureValid, nil
+}
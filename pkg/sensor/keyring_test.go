@@ -0,0 +1,57 @@
+package sensor
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRing(t *testing.T) {
+	key, err := RandomKey()
+	assert.NoError(t, err)
+	mac := GenerateMAC("Sensor1")
+	ring := NewKeyRing()
+
+	data, _, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", false, mac, key, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	status, _ := ring.Check(data)
+	assert.Equal(t, SignatureNoKey, status)
+
+	pair, _, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", true, mac, key, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	status, _ = ring.Check(pair)
+	assert.Equal(t, SignaturePair, status)
+	status, err = ring.Check(data)
+	assert.NoError(t, err)
+	assert.Equal(t, SignaturePairKey, status, "Key from a pairing message")
+
+	ring.Add(mac.String(), []byte("wrong"))
+	status, err = ring.Check(data)
+	assert.Error(t, err)
+	assert.Equal(t, SignatureInvalid, status)
+
+	store, err := LoadKeyStore(filepath.Join(t.TempDir(), "sensors.json"))
+	assert.NoError(t, err)
+	store.Put(&Identity{Name: "Sensor1", MAC: mac, Key: key, Type: SensorType_REMOTE, UnitID: 5})
+	ring.AddStore(store)
+	status, err = ring.Check(data)
+	assert.NoError(t, err)
+	assert.Equal(t, SignatureValid, status, "Key from the store")
+
+	forged, _, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", true, mac, []byte("forged"), SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	status, _ = ring.Check(forged)
+	assert.Equal(t, SignaturePair, status)
+	status, err = ring.Check(data)
+	assert.NoError(t, err)
+	assert.Equal(t, SignatureValid, status, "Pairing messages don't replace stored keys")
+
+	out, err := json.Marshal(SignatureInvalid)
+	assert.NoError(t, err)
+	assert.Equal(t, `"invalid"`, string(out))
+	var s SignatureStatus
+	assert.NoError(t, json.Unmarshal([]byte(`"no-key"`), &s))
+	assert.Equal(t, SignatureNoKey, s)
+}
@@ -0,0 +1,217 @@
+package sensor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Identity is everything needed to keep sending as a paired sensor
+type Identity struct {
+	Name   string     `json:"name"`
+	MAC    MAC        `json:"mac"`
+	Key    []byte     `json:"key"`
+	Type   SensorType `json:"type"`
+	UnitID int        `json:"unitId"`
+	// LegacyMAC sends the unpadded MAC from LegacyGenerateMAC for sensors paired
+	// before MACs were zero padded
+	LegacyMAC bool `json:"legacyMac,omitempty"`
+	// Seq is the last sequence number sent
+	Seq     int        `json:"seq"`
+	Created time.Time  `json:"created"`
+	Rotated *time.Time `json:"rotated,omitempty"`
+}
+
+// NewIdentity creates an identity with a random key and a MAC generated from name
+func NewIdentity(name string, sensorType SensorType, unitId int) (*Identity, error) {
+	id := &Identity{
+		Name:    name,
+		MAC:     GenerateMAC(name),
+		Type:    sensorType,
+		UnitID:  unitId,
+		Seq:     GenerateSeqNum(),
+		Created: time.Now().UTC(),
+	}
+	key, err := RandomKey()
+	if err != nil {
+		return nil, err
+	}
+	id.Key = key
+	return id, nil
+}
+
+// IdentityFromPair captures a sensor's identity from its pairing message so it
+// can be impersonated. The key is the message hash and Seq is the message's
+// sequence number, so the next message built continues where the sensor left off.
+func IdentityFromPair(msg *SensorMsg) (*Identity, error) {
+	if msg.GetType() != MessageType_PAIR {
+		return nil, errors.New("not a pairing message")
+	}
+	data := msg.GetDataWithHash().GetSensorData()
+	mac, err := ParseMAC(data.GetMac())
+	if err != nil {
+		return nil, err
+	}
+	key, err := GetHashBytes(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key: %w", err)
+	}
+	return &Identity{
+		Name:    data.GetSensorName(),
+		MAC:     mac,
+		Key:     key,
+		Type:    data.GetSensorType(),
+		UnitID:  int(data.GetUnitId()),
+		Seq:     int(data.GetSeqNum()),
+		Created: time.Now().UTC(),
+	}, nil
+}
+
+// RandomKey generates a new signing key
+func RandomKey() ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %w", err)
+	}
+	return key, nil
+}
+
+// Rotate replaces the key with a new random one, the sensor must be paired again
+func (id *Identity) Rotate() error {
+	key, err := RandomKey()
+	if err != nil {
+		return err
+	}
+	id.Key = key
+	now := time.Now().UTC()
+	id.Rotated = &now
+	return nil
+}
+
+// NextSeq increments and returns the sequence number
+func (id *Identity) NextSeq() int {
+	id.Seq++
+	return id.Seq
+}
+
+// Build builds a message as this sensor using the next sequence number. Out of
+// range temperatures are an error.
+func (id *Identity) Build(temp Temperature, pair bool) (*SensorMsg, []byte, error) {
+	code, err := Encode(temp, RoundNearest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return id.BuildCode(code, pair)
+}
+
+// BuildCode is Build with an already encoded temperature, see Encoder
+func (id *Identity) BuildCode(code int32, pair bool) (*SensorMsg, []byte, error) {
+	return buildMessage(code, id.Name, pair, id.WireMAC(), id.Key, id.Type, id.NextSeq(), id.UnitID)
+}
+
+// WireMAC is the MAC exactly as it's sent
+func (id *Identity) WireMAC() string {
+	if id.LegacyMAC {
+		return LegacyGenerateMAC(id.Name)
+	}
+	return id.MAC.String()
+}
+
+// KeyStore persists sensor identities as JSON keyed by sensor name
+type KeyStore struct {
+	path    string
+	lock    sync.Mutex
+	sensors map[string]*Identity
+}
+
+type keyStoreFile struct {
+	Sensors []*Identity `json:"sensors"`
+}
+
+// DefaultKeyStorePath is sensors.json in the user's config directory
+func DefaultKeyStorePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "sensors.json"
+	}
+	return filepath.Join(dir, "tstat-sensor-go", "sensors.json")
+}
+
+// LoadKeyStore reads the store at path, a missing file is an empty store
+func LoadKeyStore(path string) (*KeyStore, error) {
+	k := &KeyStore{path: path, sensors: make(map[string]*Identity)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading key store: %w", err)
+	}
+	f := keyStoreFile{}
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key store [%s]: %w", path, err)
+	}
+	for _, id := range f.Sensors {
+		k.sensors[id.Name] = id
+	}
+	return k, nil
+}
+
+// Get returns the identity for the named sensor
+func (k *KeyStore) Get(name string) (*Identity, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	id, ok := k.sensors[name]
+	return id, ok
+}
+
+// Put adds or replaces an identity, call Save to persist it
+func (k *KeyStore) Put(id *Identity) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.sensors[id.Name] = id
+}
+
+// List returns all identities sorted by name
+func (k *KeyStore) List() []*Identity {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	r := make([]*Identity, 0, len(k.sensors))
+	for _, id := range k.sensors {
+		r = append(r, id)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r
+}
+
+// Save atomically writes the store, it's only readable by the owner since it holds keys
+func (k *KeyStore) Save() error {
+	data, err := json.MarshalIndent(keyStoreFile{Sensors: k.List()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling key store: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(k.path), 0700)
+	if err != nil {
+		return fmt.Errorf("error creating key store directory: %w", err)
+	}
+	tmp := k.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing key store: %w", err)
+	}
+	err = os.Rename(tmp, k.path)
+	if err != nil {
+		return fmt.Errorf("error replacing key store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package sensor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "sensors.json")
+	store, err := LoadKeyStore(path)
+	assert.NoError(t, err, "Missing store is empty")
+	assert.Empty(t, store.List())
+
+	id, err := NewIdentity("Sensor1", SensorType_SUPPLY, 2)
+	assert.NoError(t, err)
+	assert.NotEqual(t, GenerateKey("Sensor1"), id.Key, "Key is random")
+	store.Put(id)
+	assert.NoError(t, store.Save())
+
+	loaded, err := LoadKeyStore(path)
+	assert.NoError(t, err)
+	got, ok := loaded.Get("Sensor1")
+	assert.True(t, ok)
+	assert.Equal(t, id.Key, got.Key)
+	assert.Equal(t, SensorType_SUPPLY, got.Type)
+	assert.Equal(t, 2, got.UnitID)
+
+	seq := got.Seq
+	msg, _, err := got.Build(Temperature{Value: 68}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(seq+1), msg.DataWithHash.SensorData.GetSeqNum(), "Sequence increments")
+	assert.NoError(t, ValidateSignature(msg, id.Key))
+
+	old := got.Key
+	assert.NoError(t, got.Rotate())
+	assert.NotEqual(t, old, got.Key)
+	assert.NotNil(t, got.Rotated)
+}
+
+func TestIdentityFromPair(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	mac := MAC{0x20, 0x91, 0x48, 0x25, 0xa9, 0xe6}
+	pair, _, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", true, mac, key, SensorType_OUTDOOR, 41, 3)
+	assert.NoError(t, err)
+
+	id, err := IdentityFromPair(pair)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensor1", id.Name)
+	assert.Equal(t, mac, id.MAC)
+	assert.Equal(t, key, id.Key)
+	assert.Equal(t, SensorType_OUTDOOR, id.Type)
+	assert.Equal(t, 3, id.UnitID)
+
+	msg, _, err := id.Build(Temperature{Value: 70}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(42), msg.DataWithHash.SensorData.GetSeqNum(), "Continues the sensor's sequence")
+	assert.NoError(t, ValidateSignature(msg, key), "Signs with the learned key")
+
+	_, err = IdentityFromPair(msg)
+	assert.Error(t, err, "Data messages don't carry the key")
+}
@@ -0,0 +1,83 @@
+package sensor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MAC is a sensor MAC address. On the wire real sensors send it as 12 lower case
+// hex digits with no separators, which is what String returns.
+type MAC [6]byte
+
+// ParseMAC accepts colon (aa:bb:cc:dd:ee:ff), dash (aa-bb-cc-dd-ee-ff), dotted
+// (aabb.ccdd.eeff) and bare (aabbccddeeff) forms in any case.
+func ParseMAC(s string) (MAC, error) {
+	m := MAC{}
+	clean := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(s))
+	if len(clean) != 12 {
+		return m, fmt.Errorf("invalid MAC [%s]: need 12 hex digits", s)
+	}
+	_, err := hex.Decode(m[:], []byte(clean))
+	if err != nil {
+		return m, fmt.Errorf("invalid MAC [%s]: %w", s, err)
+	}
+	return m, nil
+}
+
+// String is the wire format
+func (m MAC) String() string {
+	return hex.EncodeToString(m[:])
+}
+
+// HardwareAddr converts to the standard library type, its String is colon separated
+func (m MAC) HardwareAddr() net.HardwareAddr {
+	return net.HardwareAddr(m[:])
+}
+
+func (m MAC) IsZero() bool {
+	return m == MAC{}
+}
+
+// LocallyAdministered reports whether the locally administered bit is set, meaning
+// the address won't collide with a vendor assigned one.
+// https://en.wikipedia.org/wiki/MAC_address#Ranges_of_group_and_locally_administered_addresses
+func (m MAC) LocallyAdministered() bool {
+	return m[0]&0x02 != 0
+}
+
+// Local returns m with the locally administered bit set and the multicast bit cleared
+func (m MAC) Local() MAC {
+	m[0] = (m[0] | 0x02) &^ 0x01
+	return m
+}
+
+func (m MAC) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *MAC) UnmarshalText(text []byte) error {
+	parsed, err := ParseMAC(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// GenerateMAC derives a locally administered MAC from sensorName
+func GenerateMAC(sensorName string) MAC {
+	hash := sha256.Sum256([]byte(sensorName))
+	return MAC{0x0a, hash[0], hash[1], hash[2], hash[3], hash[4]}.Local()
+}
+
+// LegacyGenerateMAC is how MACs were generated before the MAC type. Bytes under
+// 0x10 weren't zero padded so the result can be shorter than 12 digits. It's only
+// for sensors that were already paired using it, the thermostat knows them by this
+// exact string.
+func LegacyGenerateMAC(sensorName string) string {
+	hash := sha256.Sum256([]byte(sensorName))
+	return fmt.Sprintf("0a%x%x%x%x%x", hash[0], hash[1], hash[2], hash[3], hash[4])
+}
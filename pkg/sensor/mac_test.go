@@ -0,0 +1,51 @@
+package sensor
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMAC(t *testing.T) {
+	expected := MAC{0x20, 0x91, 0x48, 0x25, 0xa9, 0xe6}
+	for _, s := range []string{"20914825a9e6", "20:91:48:25:A9:E6", "20-91-48-25-a9-e6", "2091.4825.a9e6", " 20914825a9e6\n"} {
+		t.Run(s, func(t *testing.T) {
+			m, err := ParseMAC(s)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, m)
+			assert.Equal(t, "20914825a9e6", m.String(), "Wire format")
+		})
+	}
+	for _, s := range []string{"", "20914825a9e", "20914825a9e6ff", "20914825a9eg"} {
+		t.Run(s, func(t *testing.T) {
+			_, err := ParseMAC(s)
+			assert.Error(t, err)
+		})
+	}
+	assert.Equal(t, "20:91:48:25:a9:e6", expected.HardwareAddr().String())
+}
+
+func TestGenerateMAC(t *testing.T) {
+	for _, name := range []string{"Sensor1", "S1", "Living Room", ""} {
+		m := GenerateMAC(name)
+		assert.Len(t, m.String(), 12)
+		assert.True(t, m.LocallyAdministered())
+		assert.Equal(t, byte(0), m[0]&0x01, "Unicast")
+	}
+	// The third hash byte of this name is under 0x10 which the old format didn't pad
+	assert.Equal(t, "0a935c0d527b", GenerateMAC("Sensor5").String())
+	assert.Equal(t, "0a935cd527b", LegacyGenerateMAC("Sensor5"))
+	// When every byte is 0x10 or over both agree
+	assert.Equal(t, GenerateMAC("Sensor1").String(), LegacyGenerateMAC("Sensor1"))
+}
+
+func TestMACJSON(t *testing.T) {
+	m := GenerateMAC("Sensor1")
+	data, err := json.Marshal(m)
+	assert.NoError(t, err)
+	var got MAC
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, m, got)
+	assert.Error(t, json.Unmarshal([]byte(`"nope"`), &got))
+}
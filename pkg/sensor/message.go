@@ -7,8 +7,10 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"math"
-	"net"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -20,55 +22,83 @@ type Temperature struct {
 	Celsius bool
 }
 
-var keyData = make(map[string][]byte)
+// dumpKeys are the keys DumpMessage has seen
+var dumpKeys = NewKeyRing()
 
-func (t Temperature) ToMsg() *int32 {
-	tempF := t.Value
+// Fahrenheit returns the value in degrees Fahrenheit
+func (t Temperature) Fahrenheit() float64 {
 	if t.Celsius {
-		tempF = (tempF * 1.8) + 32
+		return (t.Value * 1.8) + 32
+	}
+	return t.Value
+}
+
+// In returns the temperature converted to Celsius or Fahrenheit
+func (t Temperature) In(celsius bool) Temperature {
+	if t.Celsius == celsius {
+		return t
 	}
-	// Determined experimentally
-	raw := float64(tempF+40) / 0.9
-	rounded := int32(math.Round(raw))
+	if celsius {
+		return Temperature{Value: (t.Value - 32) / 1.8, Celsius: true}
+	}
+	return Temperature{Value: t.Fahrenheit()}
+}
+
+// ToMsg rounds the temperature to the nearest wire code without range checking,
+// see Encode
+func (t Temperature) ToMsg() *int32 {
+	return t.Round(RoundNearest)
+}
+
+// Round rounds the temperature to a wire code with the given rounding mode
+func (t Temperature) Round(mode Rounding) *int32 {
+	raw := RawCode(t)
+	rounded := int32(mode.round(raw))
 	log.Trace().
 		Float64("input", t.Value).
 		Bool("celsius", t.Celsius).
-		Float64("tempF", tempF).
+		Float64("tempF", t.Fahrenheit()).
 		Float64("raw", raw).
+		Str("rounding", mode.String()).
 		Int32("rounded", rounded).
 		Msg("Converting temp")
 	return &rounded
 }
 
+// DumpMessage prints msg, checking its signature against keys from the pairing
+// messages it has been passed
 func DumpMessage(msg *SensorMsg) {
+	status, err := dumpKeys.Check(msg)
+	PrintMessage(os.Stdout, msg, status, err)
+}
+
+// PrintMessage prints msg along with its signature status as DumpMessage does
+func PrintMessage(w io.Writer, msg *SensorMsg, status SignatureStatus, sigErr error) {
 	sigStatus := ""
-	if *msg.Type == MessageType_PAIR {
-		key, err := GetHashBytes(msg)
-		if err != nil {
-			sigStatus = fmt.Sprintf("Error decoding hash: %v", err)
-		} else {
-			sigStatus = "Pairing message (key received)"
-			keyData[*msg.DataWithHash.SensorData.Mac] = key
-		}
+	switch status {
+	case SignaturePair:
+		sigStatus = "Pairing message (key received)"
+	case SignatureValid:
+		sigStatus = "Valid signature"
+	case SignaturePairKey:
+		sigStatus = "Valid signature (key from a pairing message, not the key store)"
+	case SignatureNoKey:
+		sigStatus = "No key seen, press pair button on device to receive key data"
+	default:
+		sigStatus = fmt.Sprintf("%v", sigErr)
+	}
+	rawMAC := msg.GetDataWithHash().GetSensorData().GetMac()
+	if mac, err := ParseMAC(rawMAC); err == nil {
+		fmt.Fprintf(w, "MAC: %s\n", mac.HardwareAddr())
 	} else {
-		key, ok := keyData[*msg.DataWithHash.SensorData.Mac]
-		if ok {
-			err := ValidateSignature(msg, key)
-			if err != nil {
-				sigStatus = fmt.Sprintf("%v", err)
-			} else {
-				sigStatus = "Valid signature"
-			}
-		} else {
-			sigStatus = "No key seen, press pair button on device to receive key data"
-		}
+		fmt.Fprintf(w, "MAC: %s (not a standard MAC)\n", rawMAC)
 	}
-	fmt.Printf("Signature: %s\n", sigStatus)
-	fmt.Println(msg.String())
+	fmt.Fprintf(w, "Signature: %s\n", sigStatus)
+	fmt.Fprintln(w, msg.String())
 }
 
 func GetHashBytes(msg *SensorMsg) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(*msg.DataWithHash.Hash)
+	return base64.StdEncoding.DecodeString(msg.GetDataWithHash().GetHash())
 }
 
 func ValidateSignature(msg *SensorMsg, key []byte) error {
@@ -91,7 +121,7 @@ func ValidateSignature(msg *SensorMsg, key []byte) error {
 
 func CalculateSignature(msg *SensorMsg, key []byte) ([]byte, error) {
 	h := hmac.New(sha256.New, key)
-	data, err := proto.Marshal(msg.DataWithHash.SensorData)
+	data, err := proto.Marshal(msg.GetDataWithHash().GetSensorData())
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling submsg: %w", err)
 	}
@@ -99,39 +129,18 @@ func CalculateSignature(msg *SensorMsg, key []byte) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
+// Send sends msg to targetAddr over UDP, or broadcasts it if targetAddr is empty
 func Send(msg *SensorMsg, targetAddr string) error {
-	if targetAddr == "" {
-		targetAddr = "255.255.255.255"
-	}
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:5001", targetAddr))
-	if err != nil {
-		return fmt.Errorf("error resolving broadcast address: %w", err)
-	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return fmt.Errorf("error dialing broadcast address: %w", err)
-	}
-	defer conn.Close()
-
-	data, err := proto.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("error marshalling message: %w", err)
-	}
-	_, err = conn.Write(data)
+	t, err := NewUnicastTransport(targetAddr)
 	if err != nil {
-		return fmt.Errorf("error writing packet: %w", err)
+		return err
 	}
-	log.Trace().Stringer("msg", msg).Str("address", targetAddr).Msg("Sent message")
-	return nil
-}
-
-func GenerateMAC(sensorName string) string {
-	hash := sha256.Sum256([]byte(sensorName))
-	// https://en.wikipedia.org/wiki/MAC_address#Ranges_of_group_and_locally_administered_addresses
-	r := fmt.Sprintf("0a%x%x%x%x%x", hash[0], hash[1], hash[2], hash[3], hash[4])
-	return r
+	defer t.Close()
+	return SendTransport(t, msg)
 }
 
+// GenerateKey derives a key from sensorName. Anyone who knows the name can derive it
+// too, use NewIdentity and a KeyStore for a random key.
 func GenerateKey(sensorName string) []byte {
 	r := sha256.Sum256([]byte(sensorName))
 	return r[:]
@@ -148,27 +157,48 @@ func GenerateSeqNum() int {
 }
 
 // SimpleSend is a simple interface to send temp data. If pair is set
-// it's sent as a pairing message, otherwise a normal data packet. If mac is empty it is generated
+// it's sent as a pairing message, otherwise a normal data packet. If mac is zero it is generated
 // from the sensorName. If key is nil it is generated fro the sensorName. If seqNum is -1 it is
 // generated based on time of day. If sensorType is nil REMOTE is assumed. If addr is nil
 // the broadcast address is used (this is how normal sensors work).
-func SimpleSend(temp Temperature, sensorName string, pair bool, mac string, key []byte, sensorType SensorType, seqNum int, unitId int, addr string) error {
-	if unitId < 0 || unitId > 19 {
-		return fmt.Errorf("unitId [%d] out of range (0-19)", unitId)
+func SimpleSend(temp Temperature, sensorName string, pair bool, mac MAC, key []byte, sensorType SensorType, seqNum int, unitId int, addr string) error {
+	msg, _, err := SimpleBuild(temp, sensorName, pair, mac, key, sensorType, seqNum, unitId)
+	if err != nil {
+		return err
 	}
-	var seqNumP *int32
+	return Send(msg, addr)
+}
+
+// SimpleBuild builds and signs the message SimpleSend would send without touching
+// the network. It returns the message along with its marshalled wire bytes. The
+// arguments are defaulted the same way as SimpleSend.
+func SimpleBuild(temp Temperature, sensorName string, pair bool, mac MAC, key []byte, sensorType SensorType, seqNum int, unitId int) (*SensorMsg, []byte, error) {
 	if seqNum == -1 {
-		seqNumP = intPointer(GenerateSeqNum())
-	} else {
-		seqNumP = intPointer(seqNum)
+		seqNum = GenerateSeqNum()
 	}
-	if mac == "" {
+	if mac.IsZero() {
 		mac = GenerateMAC(sensorName)
 	}
 	if key == nil {
 		key = GenerateKey(sensorName)
 	}
+	code, err := Encode(temp, RoundNearest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buildMessage(code, sensorName, pair, mac.String(), key, sensorType, seqNum, unitId)
+}
+
+// buildMessage builds and signs a message with everything already defaulted
+func buildMessage(code int32, sensorName string, pair bool, mac string, key []byte, sensorType SensorType, seqNum int, unitId int) (*SensorMsg, []byte, error) {
+	if unitId < 0 || unitId > 19 {
+		return nil, nil, fmt.Errorf("unitId [%d] out of range (0-19)", unitId)
+	}
+	if code < MinCode || code > MaxCode {
+		return nil, nil, fmt.Errorf("%w: code [%d] outside %d to %d", ErrOutOfRange, code, MinCode, MaxCode)
+	}
 	unitIdP := intPointer(unitId)
+	seqNumP := intPointer(seqNum)
 
 	msg := &SensorMsg{
 		DataWithHash: &DataWithHash{
@@ -177,7 +207,7 @@ func SimpleSend(temp Temperature, sensorName string, pair bool, mac string, key
 				Mac:        &mac,
 				SensorType: &sensorType,
 				Battery:    intPointer(95),
-				Temp:       temp.ToMsg(),
+				Temp:       &code,
 				SensorName: &sensorName,
 				SeqNum:     seqNumP,
 			},
@@ -192,12 +222,44 @@ func SimpleSend(temp Temperature, sensorName string, pair bool, mac string, key
 		msg.Type = messageTypePointer(MessageType_DATA)
 		sig, err := CalculateSignature(msg, key)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		sigStr := base64.StdEncoding.EncodeToString(sig)
 		msg.DataWithHash.Hash = &sigStr
 	}
-	return Send(msg, addr)
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshalling message: %w", err)
+	}
+	return msg, data, nil
+}
+
+// ParseSensorType parses a sensor type name (outdoor, remote, supply, return)
+func ParseSensorType(s string) (SensorType, error) {
+	v, ok := SensorType_value[strings.ToUpper(s)]
+	if !ok {
+		return SensorType_REMOTE, fmt.Errorf("invalid sensor type [%s]", s)
+	}
+	return SensorType(v), nil
+}
+
+// MarshalJSON encodes the type by name, UnmarshalJSON accepts names or numbers
+func (x SensorType) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(x.String())), nil
+}
+
+// MarshalJSON encodes the type by name, UnmarshalJSON accepts names or numbers
+func (x MessageType) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(x.String())), nil
+}
+
+// String names the power source, PowerSource isn't in the proto descriptor so
+// it doesn't get a generated one
+func (x PowerSource) String() string {
+	if name, ok := PowerSource_name[int32(x)]; ok {
+		return name
+	}
+	return strconv.Itoa(int(x))
 }
 
 func SetUnknowns(msg *SensorMsg) {
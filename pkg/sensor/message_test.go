@@ -54,3 +54,33 @@ func TestTempFToMsg(t *testing.T) {
 	test(2, -38)
 	test(0, -40)
 }
+
+func TestSimpleBuild(t *testing.T) {
+	key := GenerateKey("Sensor1")
+	msg, data, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageType_DATA, msg.GetType())
+	assert.Equal(t, int32(120), msg.DataWithHash.SensorData.GetTemp())
+	assert.NoError(t, ValidateSignature(msg, key), "Message validates")
+
+	decoded := &SensorMsg{}
+	assert.NoError(t, proto.Unmarshal(data, decoded))
+	assert.True(t, proto.Equal(msg, decoded), "Bytes match message")
+
+	msg, _, err = SimpleBuild(Temperature{Value: 68}, "Sensor1", true, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageType_PAIR, msg.GetType())
+	hash, err := GetHashBytes(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, key, hash, "Pairing message carries key")
+
+	_, _, err = SimpleBuild(Temperature{Value: 68}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 20)
+	assert.Error(t, err)
+}
+
+func TestTemperatureIn(t *testing.T) {
+	assert.Equal(t, Temperature{Value: 68}, Temperature{Value: 20, Celsius: true}.In(false))
+	assert.Equal(t, Temperature{Value: 20, Celsius: true}, Temperature{Value: 68}.In(true))
+	assert.Equal(t, Temperature{Value: 68}, Temperature{Value: 68}.In(false))
+	assert.Equal(t, 68.0, Temperature{Value: 20, Celsius: true}.Fahrenheit())
+}
@@ -0,0 +1,198 @@
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// SenderStats are running totals for a Sender
+type SenderStats struct {
+	Sent      uint64
+	Failed    uint64
+	Bytes     uint64
+	LastSent  time.Time
+	LastError error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// Sender is a long lived sender that reuses a single Transport (normally a UDP
+// socket) for every message. It's safe for concurrent use.
+type Sender struct {
+	// WriteTimeout bounds each write if the transport supports deadlines, zero
+	// means only the context deadline applies
+	WriteTimeout time.Duration
+
+	transport Transport
+	lock      sync.Mutex
+	stats     SenderStats
+}
+
+// NewSender sends over t, closing the Sender closes t
+func NewSender(t Transport) *Sender {
+	return &Sender{transport: t}
+}
+
+// NewUDPSender opens a socket that sends to addr, or broadcasts if addr is empty
+func NewUDPSender(ctx context.Context, addr string) (*Sender, error) {
+	if addr == "" {
+		addr = "255.255.255.255"
+	}
+	t, err := NewUDPTransportContext(ctx, ":0", net.JoinHostPort(addr, fmt.Sprint(Port)))
+	if err != nil {
+		return nil, err
+	}
+	return NewSender(t), nil
+}
+
+// Send marshals and sends msg
+func (s *Sender) Send(ctx context.Context, msg *SensorMsg) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return s.record(0, fmt.Errorf("error marshalling message: %w", err))
+	}
+	err = s.SendPacket(ctx, data)
+	if err == nil {
+		log.Trace().Stringer("msg", msg).Msg("Sent message")
+	}
+	return err
+}
+
+// SendPacket sends already marshalled bytes. Cancelling ctx aborts a blocked write
+// on transports that support deadlines.
+func (s *Sender) SendPacket(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return s.record(0, err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if d, ok := s.transport.(writeDeadliner); ok {
+		deadline, hasDeadline := ctx.Deadline()
+		if s.WriteTimeout > 0 {
+			timeout := time.Now().Add(s.WriteTimeout)
+			if !hasDeadline || timeout.Before(deadline) {
+				deadline = timeout
+			}
+		}
+		err := d.SetWriteDeadline(deadline)
+		if err != nil {
+			return s.recordLocked(0, fmt.Errorf("error setting write deadline: %w", err))
+		}
+		// finished stops the watcher from touching the deadline once the
+		// write is over, select may pick ctx.Done() even after done is closed
+		var deadlineLock sync.Mutex
+		finished := false
+		done := make(chan struct{})
+		defer func() {
+			deadlineLock.Lock()
+			finished = true
+			deadlineLock.Unlock()
+			close(done)
+		}()
+		go func() {
+			select {
+			case <-ctx.Done():
+				deadlineLock.Lock()
+				defer deadlineLock.Unlock()
+				if !finished {
+					// Unblock the write
+					_ = d.SetWriteDeadline(time.Unix(1, 0))
+				}
+			case <-done:
+			}
+		}()
+	}
+	err := s.transport.WritePacket(data)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return s.recordLocked(0, fmt.Errorf("error writing packet: %w", err))
+	}
+	return s.recordLocked(len(data), nil)
+}
+
+func (s *Sender) record(size int, err error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.recordLocked(size, err)
+}
+
+func (s *Sender) recordLocked(size int, err error) error {
+	if err != nil {
+		s.stats.Failed++
+		s.stats.LastError = err
+		return err
+	}
+	s.stats.Sent++
+	s.stats.Bytes += uint64(size)
+	s.stats.LastSent = time.Now()
+	return nil
+}
+
+// Stats returns a snapshot of the running totals
+func (s *Sender) Stats() SenderStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stats
+}
+
+func (s *Sender) Close() error {
+	return s.transport.Close()
+}
+
+// Burst repeats a message the way real sensors do so a single lost broadcast
+// doesn't mean a missed reading. Every copy is byte for byte identical.
+type Burst struct {
+	// Count is the total number of copies sent, less than 1 is treated as 1
+	Count int
+	// Spacing is the delay between copies
+	Spacing time.Duration
+	// Jitter adds up to this much random delay to each spacing
+	Jitter time.Duration
+}
+
+// Delay returns the wait before the next copy
+func (b Burst) Delay() time.Duration {
+	d := b.Spacing
+	if b.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(b.Jitter)))
+	}
+	return d
+}
+
+// SendBurst marshals msg once and sends it burst.Count times. It stops at the
+// first error or when ctx is done.
+func (s *Sender) SendBurst(ctx context.Context, msg *SensorMsg, burst Burst) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return s.record(0, fmt.Errorf("error marshalling message: %w", err))
+	}
+	for i := 0; i < burst.Count || i == 0; i++ {
+		if i > 0 {
+			timer := time.NewTimer(burst.Delay())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return s.record(0, ctx.Err())
+			case <-timer.C:
+			}
+		}
+		err = s.SendPacket(ctx, data)
+		if err != nil {
+			return err
+		}
+	}
+	log.Trace().Stringer("msg", msg).Int("count", burst.Count).Msg("Sent burst")
+	return nil
+}
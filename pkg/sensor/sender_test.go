@@ -0,0 +1,121 @@
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSender(t *testing.T) {
+	tr := NewMemoryTransport(4)
+	s := NewSender(tr)
+	msg, data, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Send(context.Background(), msg))
+	assert.NoError(t, s.Send(context.Background(), msg))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, s.Send(ctx, msg), "Cancelled context fails")
+
+	stats := s.Stats()
+	assert.Equal(t, uint64(2), stats.Sent)
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.Equal(t, uint64(2*len(data)), stats.Bytes)
+	assert.ErrorIs(t, stats.LastError, context.Canceled)
+	assert.Len(t, tr.Sent(), 2)
+}
+
+func TestUDPSender(t *testing.T) {
+	l, err := NewUDPTransport("127.0.0.1:0", "")
+	assert.NoError(t, err)
+	defer l.Close()
+	target := l.LocalAddr().String()
+
+	tr, err := NewUDPTransport(":0", target)
+	assert.NoError(t, err)
+	s := NewSender(tr)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.SendPacket(context.Background(), []byte(fmt.Sprint(i))))
+	}
+	buf := make([]byte, 10)
+	for i := 0; i < 3; i++ {
+		size, _, err := l.ReadPacket(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(i), string(buf[:size]))
+	}
+	assert.Equal(t, uint64(3), s.Stats().Sent)
+}
+
+func TestSendBurst(t *testing.T) {
+	tr := NewMemoryTransport(10)
+	s := NewSender(tr)
+	msg, data, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, s.SendBurst(context.Background(), msg, Burst{Count: 3, Spacing: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond, "Copies are spaced")
+	sent := tr.Sent()
+	assert.Len(t, sent, 3)
+	for _, packet := range sent {
+		assert.Equal(t, data, packet, "Copies are identical")
+	}
+
+	assert.NoError(t, s.SendBurst(context.Background(), msg, Burst{}))
+	assert.Len(t, tr.Sent(), 4, "Zero count sends once")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, s.SendBurst(ctx, msg, Burst{Count: 2}))
+}
+
+// deadlineTransport cancels the send's context during each write and records
+// deadlines set after the send returned
+type deadlineTransport struct {
+	MemoryTransport
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	idle   bool
+	late   int
+}
+
+func (t *deadlineTransport) SetWriteDeadline(d time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.idle {
+		t.late++
+	}
+	return nil
+}
+
+func (t *deadlineTransport) WritePacket(data []byte) error {
+	t.cancel()
+	return nil
+}
+
+func TestSendPacketCancelAfterWrite(t *testing.T) {
+	tr := &deadlineTransport{}
+	s := NewSender(tr)
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		tr.lock.Lock()
+		tr.idle = false
+		tr.cancel = cancel
+		tr.lock.Unlock()
+		assert.NoError(t, s.SendPacket(ctx, []byte("x")))
+		tr.lock.Lock()
+		tr.idle = true
+		tr.lock.Unlock()
+	}
+	time.Sleep(10 * time.Millisecond)
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	assert.Zero(t, tr.late, "No deadline is set on the transport after a send returns")
+}
@@ -0,0 +1,285 @@
+package sensor
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Port is the UDP port sensors broadcast on and thermostats listen on
+const Port = 5001
+
+// Transport moves raw sensor packets. Implementations that can only go one way
+// return an error from the other direction.
+type Transport interface {
+	// WritePacket delivers a single marshalled SensorMsg
+	WritePacket(data []byte) error
+	// ReadPacket blocks until a packet arrives, copies it into buf and returns
+	// its size and where it came from
+	ReadPacket(buf []byte) (int, net.Addr, error)
+	Close() error
+}
+
+// UDPTransport sends and/or receives packets over UDP
+type UDPTransport struct {
+	conn   net.PacketConn
+	target net.Addr
+}
+
+// NewUDPTransport binds to listenAddr (":0" for any free port) and, if targetAddr
+// is not empty, sends packets to it.
+func NewUDPTransport(listenAddr string, targetAddr string) (*UDPTransport, error) {
+	return NewUDPTransportContext(context.Background(), listenAddr, targetAddr)
+}
+
+// NewUDPTransportContext is NewUDPTransport with a context for resolving and binding.
+// SO_BROADCAST is set explicitly on the socket so broadcast targets work everywhere.
+func NewUDPTransportContext(ctx context.Context, listenAddr string, targetAddr string) (*UDPTransport, error) {
+	t := &UDPTransport{}
+	network := "udp"
+	if targetAddr != "" {
+		addr, err := net.ResolveUDPAddr(network, targetAddr)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving address [%s]: %w", targetAddr, err)
+		}
+		t.target = addr
+		// A dual stack socket can't send to the IPv4 broadcast address everywhere
+		if addr.IP.Equal(net.IPv4bcast) {
+			network = "udp4"
+		}
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = setBroadcast(fd)
+			})
+			if err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return fmt.Errorf("error setting SO_BROADCAST: %w", sockErr)
+			}
+			return nil
+		},
+	}
+	conn, err := lc.ListenPacket(ctx, network, listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on [%s]: %w", listenAddr, err)
+	}
+	t.conn = conn
+	return t, nil
+}
+
+// NewBroadcastTransport sends to the broadcast address, this is how real sensors work
+func NewBroadcastTransport() (*UDPTransport, error) {
+	return NewUnicastTransport("255.255.255.255")
+}
+
+// NewUnicastTransport sends directly to host (usually the thermostat)
+func NewUnicastTransport(host string) (*UDPTransport, error) {
+	if host == "" {
+		host = "255.255.255.255"
+	}
+	return NewUDPTransport(":0", net.JoinHostPort(host, fmt.Sprint(Port)))
+}
+
+// NewListenTransport receives sensor broadcasts on the sensor port
+func NewListenTransport() (*UDPTransport, error) {
+	return NewUDPTransport(fmt.Sprintf(":%d", Port), "")
+}
+
+func (t *UDPTransport) WritePacket(data []byte) error {
+	if t.target == nil {
+		return errors.New("transport has no target address")
+	}
+	_, err := t.conn.WriteTo(data, t.target)
+	return err
+}
+
+func (t *UDPTransport) ReadPacket(buf []byte) (int, net.Addr, error) {
+	return t.conn.ReadFrom(buf)
+}
+
+// LocalAddr is the address the socket is bound to
+func (t *UDPTransport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *UDPTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// MemoryAddr is the source address reported for packets read from a MemoryTransport
+type MemoryAddr string
+
+func (a MemoryAddr) Network() string { return "memory" }
+func (a MemoryAddr) String() string  { return string(a) }
+
+// MemoryTransport is an in-memory loopback, every packet written can be read
+// back in order. It's intended for tests.
+type MemoryTransport struct {
+	lock    sync.Mutex
+	sent    [][]byte
+	packets chan []byte
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewMemoryTransport creates a loopback that buffers up to size unread packets
+func NewMemoryTransport(size int) *MemoryTransport {
+	return &MemoryTransport{
+		packets: make(chan []byte, size),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (t *MemoryTransport) WritePacket(data []byte) error {
+	packet := append([]byte(nil), data...)
+	t.lock.Lock()
+	t.sent = append(t.sent, packet)
+	t.lock.Unlock()
+	select {
+	case <-t.closed:
+		return errors.New("transport closed")
+	case t.packets <- packet:
+		return nil
+	}
+}
+
+func (t *MemoryTransport) ReadPacket(buf []byte) (int, net.Addr, error) {
+	// Drain anything already written before reporting the close
+	select {
+	case packet := <-t.packets:
+		return copy(buf, packet), MemoryAddr("memory"), nil
+	default:
+	}
+	select {
+	case <-t.closed:
+		return 0, nil, io.EOF
+	case packet := <-t.packets:
+		return copy(buf, packet), MemoryAddr("memory"), nil
+	}
+}
+
+func (t *MemoryTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+// Sent returns a copy of every packet written, including ones already read
+func (t *MemoryTransport) Sent() [][]byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([][]byte(nil), t.sent...)
+}
+
+// FileTransport writes packets as hex, one per line, and reads them back the
+// same way. This is the same format as `send --dry-run --format hex`.
+type FileTransport struct {
+	name    string
+	w       io.Writer
+	scanner *bufio.Scanner
+	closer  io.Closer
+}
+
+// NewFileTransport writes to w and reads from r, either may be nil if that
+// direction isn't needed. If either is an io.Closer it's closed with the transport.
+func NewFileTransport(name string, r io.Reader, w io.Writer) *FileTransport {
+	t := &FileTransport{name: name, w: w}
+	if r != nil {
+		t.scanner = bufio.NewScanner(r)
+		if c, ok := r.(io.Closer); ok {
+			t.closer = c
+		}
+	}
+	if c, ok := w.(io.Closer); ok {
+		t.closer = c
+	}
+	return t
+}
+
+func (t *FileTransport) WritePacket(data []byte) error {
+	if t.w == nil {
+		return errors.New("transport is read only")
+	}
+	_, err := fmt.Fprintln(t.w, hex.EncodeToString(data))
+	return err
+}
+
+func (t *FileTransport) ReadPacket(buf []byte) (int, net.Addr, error) {
+	if t.scanner == nil {
+		return 0, nil, errors.New("transport is write only")
+	}
+	for t.scanner.Scan() {
+		line := strings.TrimSpace(t.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		data, err := hex.DecodeString(line)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error decoding packet: %w", err)
+		}
+		return copy(buf, data), MemoryAddr(t.name), nil
+	}
+	if err := t.scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, io.EOF
+}
+
+func (t *FileTransport) Close() error {
+	if t.closer == nil {
+		return nil
+	}
+	return t.closer.Close()
+}
+
+// SendTransport marshals msg and writes it to t
+func SendTransport(t Transport, msg *SensorMsg) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshalling message: %w", err)
+	}
+	err = t.WritePacket(data)
+	if err != nil {
+		return fmt.Errorf("error writing packet: %w", err)
+	}
+	log.Trace().Stringer("msg", msg).Msg("Sent message")
+	return nil
+}
+
+// Listen reads packets from t until it returns an error, calling handle for each
+// one. Packets that fail to unmarshal are passed to handle with a nil msg and the
+// error, transport errors end the loop and are returned.
+func Listen(t Transport, handle func(msg *SensorMsg, from net.Addr, err error)) error {
+	buf := make([]byte, 2048)
+	for {
+		size, addr, err := t.ReadPacket(buf)
+		if err != nil {
+			return err
+		}
+		msg := &SensorMsg{}
+		err = proto.Unmarshal(buf[:size], msg)
+		if err != nil {
+			handle(nil, addr, fmt.Errorf("error unmarshalling: %w", err))
+			continue
+		}
+		handle(msg, addr, nil)
+	}
+}
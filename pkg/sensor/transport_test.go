@@ -0,0 +1,73 @@
+package sensor
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	tr := NewMemoryTransport(4)
+	msg, _, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, SendTransport(tr, msg))
+	assert.NoError(t, tr.WritePacket([]byte("garbage")))
+	assert.Len(t, tr.Sent(), 2)
+
+	var got []*SensorMsg
+	var errs []error
+	err = Listen(tr, func(m *SensorMsg, from net.Addr, err error) {
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			got = append(got, m)
+		}
+		if len(got)+len(errs) == 2 {
+			tr.Close()
+		}
+	})
+	assert.Equal(t, io.EOF, err)
+	assert.Len(t, got, 1)
+	assert.Len(t, errs, 1)
+	assert.True(t, proto.Equal(msg, got[0]), "Message survives loopback")
+}
+
+func TestFileTransport(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewFileTransport("test", nil, &buf)
+	msg, data, err := SimpleBuild(Temperature{Value: 68}, "Sensor1", false, MAC{}, nil, SensorType_REMOTE, 5, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, SendTransport(w, msg))
+	_, _, err = w.ReadPacket(make([]byte, 10))
+	assert.Error(t, err, "Write only transport can't read")
+
+	r := NewFileTransport("test", bytes.NewBufferString("# comment\n\n"+buf.String()), nil)
+	packet := make([]byte, 2048)
+	size, from, err := r.ReadPacket(packet)
+	assert.NoError(t, err)
+	assert.Equal(t, data, packet[:size])
+	assert.Equal(t, "test", from.String())
+	_, _, err = r.ReadPacket(packet)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestUDPTransportIPv6(t *testing.T) {
+	l, err := NewUDPTransport("[::1]:0", "")
+	if err != nil {
+		t.Skipf("No IPv6 loopback: %v", err)
+	}
+	defer l.Close()
+
+	tr, err := NewUDPTransport(":0", l.LocalAddr().String())
+	assert.NoError(t, err, "IPv6 unicast targets work")
+	defer tr.Close()
+	assert.NoError(t, tr.WritePacket([]byte("hi")))
+	buf := make([]byte, 10)
+	size, _, err := l.ReadPacket(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", string(buf[:size]))
+}
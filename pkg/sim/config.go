@@ -0,0 +1,229 @@
+// Package sim runs simulated sensors that send readings from local inputs
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/calibration"
+	"github.com/marwatk/tstat-sensor-go/pkg/expr"
+	"github.com/marwatk/tstat-sensor-go/pkg/input"
+	"github.com/marwatk/tstat-sensor-go/pkg/schedule"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// DefaultInterval is how often a sensor sends when its config doesn't say
+const DefaultInterval = time.Minute
+
+// Config is the simulator config file
+type Config struct {
+	// Inputs are named sources sensors read from
+	Inputs map[string]input.Config `json:"inputs"`
+	// Groups are named lists of inputs for expressions, e.g. mean(bedrooms)
+	Groups map[string][]string `json:"groups,omitempty"`
+	// Sensors are the simulated sensors to send as
+	Sensors []SensorConfig `json:"sensors"`
+}
+
+// SensorConfig describes a single simulated sensor. Its MAC and key come from the
+// key store if it's been paired or learned, otherwise they're generated from Name
+// the same way send does.
+type SensorConfig struct {
+	Name string `json:"name"`
+	// Type overrides the stored sensor type (outdoor, remote, supply, return)
+	Type string `json:"type,omitempty"`
+	// UnitID overrides the stored unit ID
+	UnitID *int `json:"unitId,omitempty"`
+	// Input is the name of the input to send
+	Input string `json:"input,omitempty"`
+	// Expression computes the value to send from inputs and groups instead of
+	// sending a single input, see package expr
+	Expression string `json:"expression,omitempty"`
+	// Schedule sends a weighted mean of inputs and groups that changes by day
+	// and time instead of sending a single input
+	Schedule schedule.Schedule `json:"schedule,omitempty"`
+	// Celsius means expressions and schedules are evaluated in Celsius, inputs
+	// are converted to match
+	Celsius bool `json:"celsius,omitempty"`
+	// Calibration corrects the value before it's encoded
+	Calibration *calibration.Calibration `json:"calibration,omitempty"`
+	// Rounding is how values are rounded to the thermostat's ~0.9F steps
+	Rounding sensor.Rounding `json:"rounding,omitempty"`
+	// DeadBand holds the sent value until the reading moves this many degrees
+	// past the rounding boundary, in Celsius if Celsius is set
+	DeadBand float64 `json:"deadBand,omitempty"`
+	// Clamp sends values outside the thermostat's range as the nearest limit
+	// instead of not sending them
+	Clamp bool `json:"clamp,omitempty"`
+	// Failsafe is what to do when inputs go stale
+	Failsafe *FailsafeConfig `json:"failsafe,omitempty"`
+	// OnChange sends when the value changes and as a heartbeat instead of
+	// every interval
+	OnChange *OnChangeConfig `json:"onChange,omitempty"`
+	// Interval is how often to send, or to check for changes with OnChange
+	Interval input.Duration `json:"interval,omitempty"`
+	// Address to send to, blank broadcasts
+	Address string       `json:"address,omitempty"`
+	Burst   *BurstConfig `json:"burst,omitempty"`
+}
+
+// BurstConfig repeats each message, see sensor.Burst
+type BurstConfig struct {
+	Count   int            `json:"count"`
+	Spacing input.Duration `json:"spacing,omitempty"`
+	Jitter  input.Duration `json:"jitter,omitempty"`
+}
+
+func (b *BurstConfig) burst() sensor.Burst {
+	if b == nil {
+		return sensor.Burst{Count: 1}
+	}
+	return sensor.Burst{Count: b.Count, Spacing: time.Duration(b.Spacing), Jitter: time.Duration(b.Jitter)}
+}
+
+// Sensor returns the config for the named sensor
+func (c *Config) Sensor(name string) (SensorConfig, bool) {
+	for _, s := range c.Sensors {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return SensorConfig{}, false
+}
+
+// LoadConfig reads and validates a JSON config file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a JSON config
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	err := json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config: %w", err)
+	}
+	return c, c.Validate()
+}
+
+// sensorInputs returns the name of every input the sensor reads, directly or
+// through a group
+func (c *Config) sensorInputs(s SensorConfig) ([]string, error) {
+	modes := 0
+	for _, set := range []bool{s.Input != "", s.Expression != "", s.Schedule != nil} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		return nil, fmt.Errorf("needs exactly one of input, expression or schedule")
+	}
+	var refs []string
+	switch {
+	case s.Input != "":
+		refs = []string{s.Input}
+	case s.Expression != "":
+		e, err := expr.Parse(s.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression: %w", err)
+		}
+		refs = e.Vars()
+	default:
+		err := s.Schedule.Compile()
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, e := range s.Schedule {
+			for _, name := range e.Inputs() {
+				if !seen[name] {
+					refs = append(refs, name)
+					seen[name] = true
+				}
+			}
+		}
+	}
+	var names []string
+	for _, v := range refs {
+		if _, ok := c.Inputs[v]; ok {
+			names = append(names, v)
+		} else if group, ok := c.Groups[v]; ok && s.Input == "" {
+			names = append(names, group...)
+		} else {
+			return nil, fmt.Errorf("unknown input or group [%s]", v)
+		}
+	}
+	return names, nil
+}
+
+// Validate checks that every sensor is complete and refers to inputs that exist
+func (c *Config) Validate() error {
+	for name, members := range c.Groups {
+		if _, ok := c.Inputs[name]; ok {
+			return fmt.Errorf("group [%s] has the same name as an input", name)
+		}
+		for _, m := range members {
+			if _, ok := c.Inputs[m]; !ok {
+				return fmt.Errorf("group [%s]: unknown input [%s]", name, m)
+			}
+		}
+	}
+	for name, in := range c.Inputs {
+		if err := in.Validate(); err != nil {
+			return fmt.Errorf("input [%s]: %w", name, err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, s := range c.Sensors {
+		if s.Name == "" {
+			return fmt.Errorf("sensor %d has no name", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("sensor [%s] is defined twice", s.Name)
+		}
+		names[s.Name] = true
+		if s.Type != "" {
+			if _, err := sensor.ParseSensorType(s.Type); err != nil {
+				return fmt.Errorf("sensor [%s]: %w", s.Name, err)
+			}
+		}
+		if s.Calibration != nil {
+			if err := s.Calibration.Validate(); err != nil {
+				return fmt.Errorf("sensor [%s]: calibration: %w", s.Name, err)
+			}
+		}
+		if s.DeadBand < 0 {
+			return fmt.Errorf("sensor [%s]: deadBand can't be negative", s.Name)
+		}
+		if s.Interval < 0 {
+			return fmt.Errorf("sensor [%s]: interval can't be negative", s.Name)
+		}
+		if s.Burst != nil && (s.Burst.Spacing < 0 || s.Burst.Jitter < 0) {
+			return fmt.Errorf("sensor [%s]: burst durations can't be negative", s.Name)
+		}
+		if s.UnitID != nil && (*s.UnitID < 0 || *s.UnitID > 19) {
+			return fmt.Errorf("sensor [%s]: unitId [%d] out of range (0-19)", s.Name, *s.UnitID)
+		}
+		_, err := c.sensorInputs(s)
+		if err != nil {
+			return fmt.Errorf("sensor [%s]: %w", s.Name, err)
+		}
+		if s.OnChange != nil {
+			if err := s.OnChange.validate(); err != nil {
+				return fmt.Errorf("sensor [%s]: %w", s.Name, err)
+			}
+		}
+		if s.Failsafe != nil {
+			if err := s.Failsafe.validate(c, s); err != nil {
+				return fmt.Errorf("sensor [%s]: %w", s.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/input"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+)
+
+// Failsafe actions
+const (
+	// FailsafeStop stops sending so the thermostat falls back to its own sensor
+	FailsafeStop = "stop"
+	// FailsafeFallback sends a fixed value
+	FailsafeFallback = "fallback"
+	// FailsafeBackup sends a backup input's reading
+	FailsafeBackup = "backup"
+)
+
+// FailsafeConfig is what a sensor does when its inputs go stale. Without one
+// the last readings keep being sent with a warning.
+type FailsafeConfig struct {
+	// Action is stop, fallback or backup
+	Action string `json:"action"`
+	// Value is sent by fallback, in Celsius if the sensor is
+	Value *float64 `json:"value,omitempty"`
+	// Input is the input backup sends
+	Input string `json:"input,omitempty"`
+	// MaxAge also engages the failsafe when the oldest reading used is older
+	// than this, for inputs that don't set their own maxAge
+	MaxAge input.Duration `json:"maxAge,omitempty"`
+}
+
+func (f *FailsafeConfig) validate(c *Config, s SensorConfig) error {
+	if f.MaxAge < 0 {
+		return fmt.Errorf("failsafe maxAge can't be negative")
+	}
+	switch f.Action {
+	case FailsafeStop:
+	case FailsafeFallback:
+		if f.Value == nil {
+			return fmt.Errorf("fallback needs a value")
+		}
+	case FailsafeBackup:
+		if _, ok := c.Inputs[f.Input]; !ok {
+			return fmt.Errorf("unknown backup input [%s]", f.Input)
+		}
+		if f.Input == s.Input {
+			return fmt.Errorf("backup input is the same as the input")
+		}
+	default:
+		return fmt.Errorf("invalid failsafe action [%s]", f.Action)
+	}
+	return nil
+}
+
+// failsafe checks v, the result of Value, against the sensor's failsafe and
+// returns what to send instead. send is false if nothing should be sent.
+func (s *Sensor) failsafe(now time.Time, v Value, err error) (Value, bool, error) {
+	f := s.Config.Failsafe
+	if f == nil {
+		return v, err == nil, err
+	}
+	maxAge := time.Duration(f.MaxAge)
+	engaged := err != nil || len(v.Stale) > 0 || (maxAge > 0 && now.Sub(v.Oldest) > maxAge)
+	s.setFailsafe(now, engaged, v, err)
+	if !engaged {
+		return v, true, nil
+	}
+	switch f.Action {
+	case FailsafeFallback:
+		return Value{Temperature: sensor.Temperature{Value: *f.Value, Celsius: s.Config.Celsius}, Oldest: now}, true, nil
+	case FailsafeBackup:
+		reading, ok := s.backup.Latest()
+		if !ok || s.backup.Stale() {
+			return Value{}, false, fmt.Errorf("backup input [%s] has no fresh reading", f.Input)
+		}
+		return Value{Temperature: reading.Temperature, Oldest: reading.Time}, true, nil
+	}
+	return Value{}, false, nil
+}
+
+// setFailsafe records and logs failsafe transitions
+func (s *Sensor) setFailsafe(now time.Time, engaged bool, v Value, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if engaged == !s.state.FailsafeSince.IsZero() {
+		return
+	}
+	if engaged {
+		s.state.FailsafeSince = now
+		s.state.FailsafeCount++
+		event := log.Warn().Str("sensor", s.Config.Name).Str("action", s.Config.Failsafe.Action)
+		if err != nil {
+			event = event.Err(err)
+		}
+		if len(v.Stale) > 0 {
+			event = event.Strs("stale", v.Stale)
+		}
+		event.Msg("Inputs stale, failsafe engaged")
+	} else {
+		log.Info().Str("sensor", s.Config.Name).Dur("after", now.Sub(s.state.FailsafeSince)).Msg("Inputs fresh, failsafe cleared")
+		s.state.FailsafeSince = time.Time{}
+	}
+}
@@ -0,0 +1,95 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFailsafe(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"inputs": {
+			"primary": {"type": "hwmon", "path": "unused", "maxAge": "20ms"},
+			"spare": {"type": "hwmon", "path": "unused"}
+		},
+		"sensors": [
+			{"name": "Stop", "input": "primary", "failsafe": {"action": "stop"}},
+			{"name": "Fallback", "input": "primary", "failsafe": {"action": "fallback", "value": 20}, "celsius": true},
+			{"name": "Backup", "input": "primary", "failsafe": {"action": "backup", "input": "spare"}},
+			{"name": "None", "input": "primary"}
+		]
+	}`))
+	assert.NoError(t, err)
+	store, err := sensor.LoadKeyStore(filepath.Join(t.TempDir(), "sensors.json"))
+	assert.NoError(t, err)
+	tr := sensor.NewMemoryTransport(100)
+	r, err := NewRunner(c, store, tr)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	r.pollers["primary"].Source = &fixedSource{temp: sensor.Temperature{Value: 70}}
+	r.pollers["spare"].Source = &fixedSource{temp: sensor.Temperature{Value: 74}}
+	assert.NoError(t, r.pollers["spare"].Poll(context.Background()))
+	ctx := context.Background()
+
+	// tick ticks every sensor and returns the codes sent by name
+	tick := func() map[string]int32 {
+		before := len(tr.Sent())
+		for _, s := range r.Sensors() {
+			_ = r.Tick(ctx, s)
+		}
+		codes := make(map[string]int32)
+		for _, data := range tr.Sent()[before:] {
+			msg := &sensor.SensorMsg{}
+			assert.NoError(t, proto.Unmarshal(data, msg))
+			codes[msg.DataWithHash.SensorData.GetSensorName()] = msg.DataWithHash.SensorData.GetTemp()
+		}
+		return codes
+	}
+	code := func(f float64) int32 {
+		return *sensor.Temperature{Value: f}.ToMsg()
+	}
+
+	codes := tick()
+	assert.Equal(t, map[string]int32{"Fallback": code(68), "Backup": code(74)}, codes, "No reading yet engages the failsafe")
+
+	assert.NoError(t, r.pollers["primary"].Poll(ctx))
+	codes = tick()
+	assert.Equal(t, map[string]int32{"Stop": code(70), "Fallback": code(70), "Backup": code(70), "None": code(70)}, codes)
+	assert.Equal(t, "", r.Sensors()[0].Status().Failsafe, "Cleared once fresh")
+
+	time.Sleep(30 * time.Millisecond)
+	codes = tick()
+	assert.Equal(t, map[string]int32{"Fallback": code(68), "Backup": code(74), "None": code(70)}, codes)
+	status := r.Status()
+	assert.Equal(t, "stop", status.Sensors[0].Failsafe)
+	assert.Equal(t, uint64(2), status.Sensors[0].FailsafeCount)
+	assert.Equal(t, uint64(1), status.Sensors[0].Sent)
+	assert.Equal(t, "", status.Sensors[3].Failsafe)
+	assert.True(t, status.Inputs[0].Stale)
+
+	var metrics bytes.Buffer
+	WriteMetrics(&metrics, status)
+	assert.Contains(t, metrics.String(), "tstat_sensor_failsafe{sensor=\"Stop\"} 1\n")
+	assert.Contains(t, metrics.String(), "tstat_sensor_failsafe{sensor=\"None\"} 0\n")
+	assert.Contains(t, metrics.String(), "tstat_input_stale{input=\"primary\"} 1\n")
+	assert.Contains(t, metrics.String(), "tstat_sensor_sent_total{sensor=\"Backup\"} 3\n")
+
+	for name, bad := range map[string]string{
+		"bad action":     `{"action": "panic"}`,
+		"no value":       `{"action": "fallback"}`,
+		"unknown backup": `{"action": "backup", "input": "nope"}`,
+		"same backup":    `{"action": "backup", "input": "primary"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(`{"inputs": {"primary": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "primary", "failsafe": ` + bad + `}]}`))
+			assert.Error(t, err)
+		})
+	}
+}
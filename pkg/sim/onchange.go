@@ -0,0 +1,116 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/input"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultHeartbeat is how often an unchanged value is resent by sensors that
+// send on change
+const DefaultHeartbeat = 10 * time.Minute
+
+// OnChangeConfig sends as soon as the encoded temperature changes and otherwise
+// only as a heartbeat, like battery sensors do. The sensor's interval is how
+// often the value is checked, it's also checked whenever an input is read.
+type OnChangeConfig struct {
+	// Heartbeat is how often an unchanged value is resent, default DefaultHeartbeat
+	Heartbeat input.Duration `json:"heartbeat,omitempty"`
+	// MinInterval is the least time between sends, changes inside it are sent
+	// once it has passed
+	MinInterval input.Duration `json:"minInterval,omitempty"`
+}
+
+func (o *OnChangeConfig) validate() error {
+	if o.Heartbeat < 0 || o.MinInterval < 0 {
+		return fmt.Errorf("onChange durations can't be negative")
+	}
+	if time.Duration(o.MinInterval) > o.heartbeat() {
+		return fmt.Errorf("onChange minInterval is longer than the heartbeat")
+	}
+	return nil
+}
+
+func (o *OnChangeConfig) heartbeat() time.Duration {
+	return o.Heartbeat.Or(DefaultHeartbeat)
+}
+
+// shouldSend decides whether a sensor that sends on change sends code at now
+func (s *Sensor) shouldSend(now time.Time, code int32) bool {
+	o := s.Config.OnChange
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := &s.state
+	if st.LastSent.IsZero() {
+		return true
+	}
+	since := now.Sub(st.LastSent)
+	if code != st.LastCode {
+		if since >= time.Duration(o.MinInterval) {
+			return true
+		}
+		if !st.pending {
+			log.Debug().Str("sensor", s.Config.Name).Int32("temp", code).Dur("wait", time.Duration(o.MinInterval)-since).Msg("Change rate limited")
+		}
+		st.pending = true
+	} else {
+		st.pending = false
+		if since >= o.heartbeat() {
+			return true
+		}
+	}
+	st.Skipped++
+	return false
+}
+
+// nextDue is how long until a rate limited change or the heartbeat is due, ok
+// is false if neither is. One that's already overdue wasn't sent at the last
+// check (the failsafe stopped it or there was an error), it waits for the next
+// regular check rather than being retried straight away.
+func (s *Sensor) nextDue(now time.Time) (time.Duration, bool) {
+	o := s.Config.OnChange
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state.LastSent.IsZero() {
+		return 0, false
+	}
+	due := s.state.LastSent.Add(o.heartbeat())
+	if s.state.pending {
+		due = s.state.LastSent.Add(time.Duration(o.MinInterval))
+	}
+	if d := due.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, false
+}
+
+// changes is signalled whenever one of the sensor's inputs is read
+func (s *Sensor) changes(ctx context.Context) <-chan struct{} {
+	merged := make(chan struct{}, 1)
+	pollers := make([]*input.Poller, 0, len(s.inputs)+1)
+	for _, p := range s.inputs {
+		pollers = append(pollers, p)
+	}
+	if s.backup != nil {
+		pollers = append(pollers, s.backup)
+	}
+	for _, p := range pollers {
+		go func(c <-chan struct{}) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-c:
+				}
+				select {
+				case merged <- struct{}{}:
+				default:
+				}
+			}
+		}(p.Subscribe())
+	}
+	return merged
+}
@@ -0,0 +1,96 @@
+package sim
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnChange(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"inputs": {"probe": {"type": "hwmon", "path": "unused"}},
+		"sensors": [{"name": "Battery", "input": "probe", "onChange": {"heartbeat": "10m", "minInterval": "1m"}}]
+	}`))
+	assert.NoError(t, err)
+	store, err := sensor.LoadKeyStore(filepath.Join(t.TempDir(), "sensors.json"))
+	assert.NoError(t, err)
+	r, err := NewRunner(c, store, sensor.NewMemoryTransport(10))
+	assert.NoError(t, err)
+	defer r.Close()
+	s := r.Sensors()[0]
+
+	source := &fixedSource{temp: sensor.Temperature{Value: 70}}
+	r.pollers["probe"].Source = source
+	assert.NoError(t, r.pollers["probe"].Poll(context.Background()))
+	assert.NoError(t, r.Check(context.Background(), s))
+	assert.Equal(t, uint64(1), s.Status().Sent, "First value is sent")
+
+	start := s.state.LastSent
+	code := *source.temp.ToMsg()
+	assert.False(t, s.shouldSend(start.Add(time.Minute), code), "Unchanged")
+	d, ok := s.nextDue(start.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 9*time.Minute, d)
+	assert.True(t, s.shouldSend(start.Add(10*time.Minute), code), "Heartbeat")
+
+	assert.False(t, s.shouldSend(start.Add(30*time.Second), code+1), "Rate limited")
+	d, ok = s.nextDue(start.Add(40 * time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 20*time.Second, d, "Due when the rate limit ends")
+	s.state.pending = false
+	_, ok = s.nextDue(start.Add(11 * time.Minute))
+	assert.False(t, ok, "Overdue heartbeat waits for the next check")
+	assert.True(t, s.shouldSend(start.Add(time.Minute), code+1))
+
+	source.temp.Value = 75
+	assert.NoError(t, r.pollers["probe"].Poll(context.Background()))
+	assert.NoError(t, r.Check(context.Background(), s))
+	assert.Equal(t, uint64(1), s.Status().Sent, "Change inside minInterval waits")
+	s.state.LastSent = s.state.LastSent.Add(-time.Minute)
+	assert.NoError(t, r.Check(context.Background(), s))
+	assert.Equal(t, uint64(2), s.Status().Sent)
+	assert.Equal(t, *source.temp.ToMsg(), *s.Status().LastCode)
+
+	for name, bad := range map[string]string{
+		"negative":     `{"heartbeat": "-1m"}`,
+		"min too long": `{"heartbeat": "1m", "minInterval": "2m"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(`{"inputs": {"p": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "p", "onChange": ` + bad + `}]}`))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestOnChangeFailsafeStop(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"inputs": {"probe": {"type": "hwmon", "path": "unused", "interval": "1h", "maxAge": "20ms"}},
+		"sensors": [{
+			"name": "Battery", "input": "probe", "interval": "100ms",
+			"onChange": {"heartbeat": "30ms"}, "failsafe": {"action": "stop"}
+		}]
+	}`))
+	assert.NoError(t, err)
+	store, err := sensor.LoadKeyStore(filepath.Join(t.TempDir(), "sensors.json"))
+	assert.NoError(t, err)
+	r, err := NewRunner(c, store, sensor.NewMemoryTransport(10))
+	assert.NoError(t, err)
+	defer r.Close()
+	r.pollers["probe"].Source = &fixedSource{temp: sensor.Temperature{Value: 70}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.Run(ctx))
+
+	s := r.Sensors()[0]
+	assert.Equal(t, uint64(1), s.Status().Sent, "Stopped once the input went stale")
+	s.lock.Lock()
+	checks := s.state.checks
+	s.lock.Unlock()
+	// The first send, the heartbeat and then every 100ms
+	assert.LessOrEqual(t, checks, uint64(8), "Overdue heartbeat doesn't spin")
+}
@@ -0,0 +1,304 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/expr"
+	"github.com/marwatk/tstat-sensor-go/pkg/input"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/rs/zerolog/log"
+)
+
+// seqReserve is how far ahead of the last sequence number sent the key store's
+// is saved. The store is only rewritten every seqReserve sends, and a crash
+// skips ahead rather than reusing sequence numbers.
+const seqReserve = 100
+
+// Runner polls the configured inputs and sends as each simulated sensor
+type Runner struct {
+	config  *Config
+	store   *sensor.KeyStore
+	pollers map[string]*input.Poller
+	sensors []*Sensor
+	senders map[string]*sensor.Sender
+	// lock serializes building messages and saving the key store, both touch
+	// identity sequence numbers
+	lock sync.Mutex
+
+	// OnSent is called after each message is sent, if set
+	OnSent func(s *Sensor, msg *sensor.SensorMsg)
+}
+
+// NewRunner prepares the inputs and sensors in config. If transport is nil each
+// sensor sends over UDP to its configured address, otherwise everything is sent
+// over transport.
+func NewRunner(config *Config, store *sensor.KeyStore, transport sensor.Transport) (*Runner, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	r := &Runner{
+		config:  config,
+		store:   store,
+		pollers: make(map[string]*input.Poller),
+		senders: make(map[string]*sensor.Sender),
+	}
+	for name, c := range config.Inputs {
+		p, err := c.NewPoller(name)
+		if err != nil {
+			return nil, fmt.Errorf("input [%s]: %w", name, err)
+		}
+		r.pollers[name] = p
+	}
+	for _, c := range config.Sensors {
+		s := &Sensor{
+			Config: c,
+			groups: config.Groups,
+			inputs: make(map[string]*input.Poller),
+			burst:  c.Burst.burst(),
+			encoder: &sensor.Encoder{
+				Rounding: c.Rounding,
+				DeadBand: c.DeadBand,
+				Clamp:    c.Clamp,
+			},
+		}
+		if c.Celsius {
+			// The encoder works in Fahrenheit
+			s.encoder.DeadBand *= 1.8
+		}
+		names, _ := config.sensorInputs(c)
+		for _, name := range names {
+			s.inputs[name] = r.pollers[name]
+		}
+		if c.Expression != "" {
+			s.expr, _ = expr.Parse(c.Expression)
+		}
+		if c.Failsafe != nil && c.Failsafe.Action == FailsafeBackup {
+			s.backup = r.pollers[c.Failsafe.Input]
+		}
+		if id, ok := store.Get(c.Name); ok {
+			s.stored = id
+			s.identity = *id
+			s.reserved = id.Seq
+		} else {
+			s.identity = sensor.Identity{
+				Name:   c.Name,
+				MAC:    sensor.GenerateMAC(c.Name),
+				Key:    sensor.GenerateKey(c.Name),
+				Type:   sensor.SensorType_REMOTE,
+				UnitID: 1,
+				Seq:    sensor.GenerateSeqNum(),
+			}
+		}
+		// Config overrides only apply to what's sent, never the key store
+		if c.Type != "" {
+			s.identity.Type, _ = sensor.ParseSensorType(c.Type)
+		}
+		if c.UnitID != nil {
+			s.identity.UnitID = *c.UnitID
+		}
+		if transport != nil {
+			s.sender = r.senders[""]
+			if s.sender == nil {
+				s.sender = sensor.NewSender(transport)
+				r.senders[""] = s.sender
+			}
+		} else {
+			s.sender = r.senders[c.Address]
+			if s.sender == nil {
+				s.sender, err = sensor.NewUDPSender(context.Background(), c.Address)
+				if err != nil {
+					r.Close()
+					return nil, err
+				}
+				r.senders[c.Address] = s.sender
+			}
+		}
+		r.sensors = append(r.sensors, s)
+	}
+	return r, nil
+}
+
+// Sensors returns the configured sensors in config order
+func (r *Runner) Sensors() []*Sensor {
+	return r.sensors
+}
+
+// Run polls inputs and sends readings until ctx is done
+func (r *Runner) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, p := range r.pollers {
+		wg.Add(1)
+		go func(p *input.Poller) {
+			defer wg.Done()
+			p.Run(ctx)
+		}(p)
+	}
+	for _, s := range r.sensors {
+		wg.Add(1)
+		go func(s *Sensor) {
+			defer wg.Done()
+			// Send as soon as every input has been read (or an interval has
+			// passed), then on the interval
+			interval := s.Config.Interval.Or(DefaultInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.ready(ctx):
+			case <-time.After(interval):
+			}
+			if s.Config.OnChange != nil {
+				r.runOnChange(ctx, s, interval)
+				return
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				err := r.Tick(ctx, s)
+				if err != nil {
+					log.Error().Err(err).Str("sensor", s.Config.Name).Msg("Error sending reading")
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+	return nil
+}
+
+// runOnChange checks the sensor every interval, whenever an input is read and
+// when a heartbeat or rate limited change is due
+func (r *Runner) runOnChange(ctx context.Context, s *Sensor, interval time.Duration) {
+	changes := s.changes(ctx)
+	for {
+		checked := time.Now()
+		s.lock.Lock()
+		s.state.checks++
+		s.lock.Unlock()
+		err := r.Check(ctx, s)
+		if err != nil {
+			log.Error().Err(err).Str("sensor", s.Config.Name).Msg("Error sending reading")
+		}
+		now := time.Now()
+		wait := checked.Add(interval).Sub(now)
+		if d, ok := s.nextDue(now); ok && d < wait {
+			wait = d
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-changes:
+			timer.Stop()
+		}
+	}
+}
+
+// Tick sends the sensor's current value. If the value can't be computed yet (an
+// input hasn't been read) nothing is sent and an error is returned, unless the
+// sensor's failsafe decides what to send.
+func (r *Runner) Tick(ctx context.Context, s *Sensor) error {
+	return r.tick(ctx, s, true)
+}
+
+// Check sends the sensor's current value if it's due, see OnChangeConfig.
+// Sensors that don't send on change always send.
+func (r *Runner) Check(ctx context.Context, s *Sensor) error {
+	return r.tick(ctx, s, s.Config.OnChange == nil)
+}
+
+func (r *Runner) tick(ctx context.Context, s *Sensor, force bool) error {
+	err := r.send(ctx, s, force)
+	if err != nil {
+		s.lock.Lock()
+		s.state.Errors++
+		s.state.LastError = err
+		s.lock.Unlock()
+	}
+	return err
+}
+
+func (r *Runner) send(ctx context.Context, s *Sensor, force bool) error {
+	now := time.Now()
+	value, err := s.Value(now)
+	value, send, err := s.failsafe(now, value, err)
+	if err != nil {
+		return fmt.Errorf("not sending: %w", err)
+	}
+	if !send {
+		log.Debug().Str("sensor", s.Config.Name).Msg("Failsafe engaged, not sending")
+		return nil
+	}
+	if len(value.Stale) > 0 {
+		log.Warn().Str("sensor", s.Config.Name).Strs("inputs", value.Stale).Time("oldest", value.Oldest).Msg("Sending with stale inputs")
+	}
+	code, err := s.encoder.Encode(value.Temperature)
+	if err != nil {
+		return fmt.Errorf("not sending: %w", err)
+	}
+	if !force && !s.shouldSend(now, code) {
+		return nil
+	}
+	r.lock.Lock()
+	msg, _, err := s.identity.BuildCode(code, false)
+	if err == nil && s.stored != nil && s.identity.Seq > s.reserved {
+		s.reserved = s.identity.Seq + seqReserve
+		s.stored.Seq = s.reserved
+		err = r.store.Save()
+	}
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = s.sender.SendBurst(ctx, msg, s.burst)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.state.Sent++
+	s.state.LastSent = time.Now()
+	s.state.LastValue = value.Temperature
+	s.state.LastCode = code
+	s.state.pending = false
+	s.lock.Unlock()
+	if r.OnSent != nil {
+		r.OnSent(s, msg)
+	}
+	log.Info().
+		Str("sensor", s.Config.Name).
+		Float64("value", value.Temperature.Value).
+		Bool("celsius", value.Temperature.Celsius).
+		Int32("temp", msg.DataWithHash.SensorData.GetTemp()).
+		Msg("Sent reading")
+	return nil
+}
+
+// Close saves the sequence numbers actually sent and closes every sender
+func (r *Runner) Close() error {
+	r.lock.Lock()
+	save := false
+	for _, s := range r.sensors {
+		if s.stored != nil && s.stored.Seq != s.identity.Seq {
+			s.stored.Seq = s.identity.Seq
+			save = true
+		}
+	}
+	var err error
+	if save {
+		err = r.store.Save()
+	}
+	r.lock.Unlock()
+	for _, s := range r.senders {
+		s.Close()
+	}
+	return err
+}
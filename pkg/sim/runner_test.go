@@ -0,0 +1,263 @@
+package sim
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/input"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"inputs": {"probe": {"type": "hwmon", "path": "/tmp/temp1_input", "interval": "10s"}},
+		"sensors": [{"name": "Living", "input": "probe", "interval": "2m", "unitId": 2, "burst": {"count": 3, "spacing": "100ms"}, "rounding": "floor", "deadBand": 0.3}]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, c.Inputs["probe"].Interval.Or(0))
+	assert.Equal(t, 2*time.Minute, c.Sensors[0].Interval.Or(0))
+	assert.Equal(t, sensor.Burst{Count: 3, Spacing: 100 * time.Millisecond}, c.Sensors[0].Burst.burst())
+	assert.Equal(t, sensor.RoundFloor, c.Sensors[0].Rounding)
+
+	for name, bad := range map[string]string{
+		"unknown input":           `{"sensors": [{"name": "Living", "input": "nope"}]}`,
+		"no name":                 `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"input": "probe"}]}`,
+		"bad type":                `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "Living", "input": "probe", "type": "attic"}]}`,
+		"bad unit":                `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "Living", "input": "probe", "unitId": 20}]}`,
+		"duplicate":               `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe"}, {"name": "A", "input": "probe"}]}`,
+		"bad duration":            `{"inputs": {"probe": {"type": "hwmon", "interval": 10}}}`,
+		"bad rounding":            `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe", "rounding": "up"}]}`,
+		"bad calibration":         `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe", "calibration": {"gain": 0}}]}`,
+		"bad dead band":           `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe", "deadBand": -1}]}`,
+		"negative input interval": `{"inputs": {"probe": {"type": "hwmon", "interval": "-1s"}}}`,
+		"negative timeout":        `{"inputs": {"probe": {"type": "exec", "command": ["true"], "timeout": "-1s"}}}`,
+		"negative max backoff":    `{"inputs": {"probe": {"type": "hwmon", "maxBackoff": "-1m"}}}`,
+		"negative input max age":  `{"inputs": {"probe": {"type": "hwmon", "maxAge": "-1m"}}}`,
+		"negative interval":       `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe", "interval": "-1s"}]}`,
+		"negative burst spacing":  `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe", "burst": {"count": 2, "spacing": "-1s"}}]}`,
+		"negative failsafe age":   `{"inputs": {"probe": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "probe", "failsafe": {"action": "stop", "maxAge": "-1m"}}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(bad))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRunnerTick(t *testing.T) {
+	dir := t.TempDir()
+	probe := filepath.Join(dir, "temp1_input")
+	assert.NoError(t, ioutil.WriteFile(probe, []byte("20000\n"), 0644))
+
+	store, err := sensor.LoadKeyStore(filepath.Join(dir, "sensors.json"))
+	assert.NoError(t, err)
+	id, err := sensor.NewIdentity("Paired", sensor.SensorType_OUTDOOR, 3)
+	assert.NoError(t, err)
+	store.Put(id)
+	seq := id.Seq
+
+	c := &Config{
+		Inputs: map[string]input.Config{"probe": {Type: "hwmon", Path: probe}},
+		Sensors: []SensorConfig{
+			{Name: "Paired", Input: "probe"},
+			{Name: "Unpaired", Input: "probe", Type: "supply"},
+		},
+	}
+	tr := sensor.NewMemoryTransport(10)
+	r, err := NewRunner(c, store, tr)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	paired, unpaired := r.Sensors()[0], r.Sensors()[1]
+	assert.Error(t, r.Tick(context.Background(), paired), "No reading yet is skipped")
+	assert.Empty(t, tr.Sent())
+
+	assert.NoError(t, r.pollers["probe"].Poll(context.Background()))
+	assert.NoError(t, r.Tick(context.Background(), paired))
+	assert.NoError(t, r.Tick(context.Background(), unpaired))
+
+	sent := tr.Sent()
+	assert.Len(t, sent, 2)
+	msg := &sensor.SensorMsg{}
+	assert.NoError(t, proto.Unmarshal(sent[0], msg))
+	assert.Equal(t, int32(120), msg.DataWithHash.SensorData.GetTemp(), "20C is 68F")
+	assert.Equal(t, sensor.SensorType_OUTDOOR, msg.DataWithHash.SensorData.GetSensorType())
+	assert.Equal(t, int32(3), msg.DataWithHash.SensorData.GetUnitId())
+	assert.NoError(t, sensor.ValidateSignature(msg, id.Key), "Signed with the stored key")
+
+	assert.NoError(t, proto.Unmarshal(sent[1], msg))
+	assert.Equal(t, sensor.SensorType_SUPPLY, msg.DataWithHash.SensorData.GetSensorType())
+	assert.NoError(t, sensor.ValidateSignature(msg, sensor.GenerateKey("Unpaired")))
+
+	assert.NoError(t, proto.Unmarshal(sent[0], msg))
+	sentSeq := int(msg.DataWithHash.SensorData.GetSeqNum())
+	assert.Equal(t, seq+1, sentSeq)
+	saved, err := sensor.LoadKeyStore(filepath.Join(dir, "sensors.json"))
+	assert.NoError(t, err)
+	got, _ := saved.Get("Paired")
+	assert.Greater(t, got.Seq, sentSeq, "Sequence numbers are reserved ahead")
+	_, ok := saved.Get("Unpaired")
+	assert.False(t, ok, "Unpaired sensors aren't saved")
+
+	// Sends within the reservation don't rewrite the store
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sensors.json"), []byte("{}"), 0644))
+	assert.NoError(t, r.Tick(context.Background(), paired))
+	saved, err = sensor.LoadKeyStore(filepath.Join(dir, "sensors.json"))
+	assert.NoError(t, err)
+	_, ok = saved.Get("Paired")
+	assert.False(t, ok, "Store isn't saved again")
+
+	assert.NoError(t, r.Close())
+	saved, err = sensor.LoadKeyStore(filepath.Join(dir, "sensors.json"))
+	assert.NoError(t, err)
+	got, _ = saved.Get("Paired")
+	assert.Equal(t, sentSeq+1, got.Seq, "Close saves the last sequence number sent")
+}
+
+func TestRunnerOverridesAreNotSaved(t *testing.T) {
+	dir := t.TempDir()
+	probe := filepath.Join(dir, "temp1_input")
+	assert.NoError(t, ioutil.WriteFile(probe, []byte("20000\n"), 0644))
+
+	store, err := sensor.LoadKeyStore(filepath.Join(dir, "sensors.json"))
+	assert.NoError(t, err)
+	id, err := sensor.NewIdentity("Paired", sensor.SensorType_OUTDOOR, 3)
+	assert.NoError(t, err)
+	store.Put(id)
+	seq := id.Seq
+
+	unitID := 5
+	c := &Config{
+		Inputs: map[string]input.Config{"probe": {Type: "hwmon", Path: probe}},
+		Sensors: []SensorConfig{
+			{Name: "Paired", Input: "probe", Type: "return", UnitID: &unitID},
+		},
+	}
+	tr := sensor.NewMemoryTransport(10)
+	r, err := NewRunner(c, store, tr)
+	assert.NoError(t, err)
+	assert.NoError(t, r.pollers["probe"].Poll(context.Background()))
+	assert.NoError(t, r.Tick(context.Background(), r.Sensors()[0]))
+	assert.NoError(t, r.Close())
+
+	msg := &sensor.SensorMsg{}
+	assert.NoError(t, proto.Unmarshal(tr.Sent()[0], msg))
+	assert.Equal(t, sensor.SensorType_RETURN, msg.DataWithHash.SensorData.GetSensorType())
+	assert.Equal(t, int32(5), msg.DataWithHash.SensorData.GetUnitId())
+
+	saved, err := sensor.LoadKeyStore(filepath.Join(dir, "sensors.json"))
+	assert.NoError(t, err)
+	got, _ := saved.Get("Paired")
+	assert.Equal(t, sensor.SensorType_OUTDOOR, got.Type)
+	assert.Equal(t, 3, got.UnitID)
+	assert.Equal(t, seq+1, got.Seq, "Only the sequence number is saved")
+}
+
+type fixedSource struct {
+	temp sensor.Temperature
+}
+
+func (s *fixedSource) Read(ctx context.Context) (sensor.Temperature, error) {
+	return s.temp, nil
+}
+
+func TestExpressionSensor(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"inputs": {
+			"living": {"type": "hwmon", "path": "unused"},
+			"kitchen": {"type": "hwmon", "path": "unused"},
+			"bed1": {"type": "hwmon", "path": "unused"},
+			"bed2": {"type": "hwmon", "path": "unused"}
+		},
+		"groups": {"bedrooms": ["bed1", "bed2"]},
+		"sensors": [
+			{"name": "Max", "expression": "max(living, kitchen) - 1.0"},
+			{"name": "Night", "expression": "mean(bedrooms) if hour() >= 22 else living", "celsius": true}
+		]
+	}`))
+	assert.NoError(t, err)
+	store, err := sensor.LoadKeyStore(filepath.Join(t.TempDir(), "sensors.json"))
+	assert.NoError(t, err)
+	r, err := NewRunner(c, store, sensor.NewMemoryTransport(10))
+	assert.NoError(t, err)
+	defer r.Close()
+
+	temps := map[string]sensor.Temperature{
+		"living":  {Value: 70},
+		"kitchen": {Value: 23, Celsius: true},
+		"bed1":    {Value: 18, Celsius: true},
+	}
+	for name, temp := range temps {
+		r.pollers[name].Source = &fixedSource{temp: temp}
+		assert.NoError(t, r.pollers[name].Poll(context.Background()))
+	}
+
+	max, night := r.Sensors()[0], r.Sensors()[1]
+	v, err := max.Value(time.Now())
+	assert.NoError(t, err)
+	assert.InDelta(t, 72.4, v.Temperature.Value, 1e-9, "Inputs are converted to Fahrenheit")
+	assert.False(t, v.Temperature.Celsius)
+
+	v, err = night.Value(time.Date(2022, 1, 1, 23, 0, 0, 0, time.Local))
+	assert.NoError(t, err)
+	assert.InDelta(t, 18, v.Temperature.Value, 1e-9, "Group members without a reading are left out")
+	assert.True(t, v.Temperature.Celsius)
+	v, err = night.Value(time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local))
+	assert.NoError(t, err)
+	assert.InDelta(t, 21.111, v.Temperature.Value, 1e-3)
+
+	for name, bad := range map[string]string{
+		"both":          `{"inputs": {"a": {"type": "hwmon"}}, "sensors": [{"name": "A", "input": "a", "expression": "a"}]}`,
+		"neither":       `{"inputs": {"a": {"type": "hwmon"}}, "sensors": [{"name": "A"}]}`,
+		"bad syntax":    `{"inputs": {"a": {"type": "hwmon"}}, "sensors": [{"name": "A", "expression": "a +"}]}`,
+		"unknown var":   `{"inputs": {"a": {"type": "hwmon"}}, "sensors": [{"name": "A", "expression": "b"}]}`,
+		"unknown group": `{"inputs": {"a": {"type": "hwmon"}}, "groups": {"g": ["b"]}}`,
+		"group clash":   `{"inputs": {"a": {"type": "hwmon"}}, "groups": {"a": ["a"]}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(bad))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestScheduleSensor(t *testing.T) {
+	c, err := ParseConfig([]byte(`{
+		"inputs": {
+			"living": {"type": "hwmon", "path": "unused"},
+			"bed1": {"type": "hwmon", "path": "unused"},
+			"bed2": {"type": "hwmon", "path": "unused"}
+		},
+		"groups": {"bedrooms": ["bed1", "bed2"]},
+		"sensors": [{"name": "Avg", "schedule": [
+			{"name": "night", "from": "22:00", "to": "07:00", "weights": {"bedrooms": 3, "living": 1}},
+			{"name": "day", "weights": {"living": 1}}
+		]}]
+	}`))
+	assert.NoError(t, err)
+	store, err := sensor.LoadKeyStore(filepath.Join(t.TempDir(), "sensors.json"))
+	assert.NoError(t, err)
+	r, err := NewRunner(c, store, sensor.NewMemoryTransport(10))
+	assert.NoError(t, err)
+	defer r.Close()
+
+	for name, temp := range map[string]float64{"living": 70, "bed1": 64, "bed2": 66} {
+		r.pollers[name].Source = &fixedSource{temp: sensor.Temperature{Value: temp}}
+		assert.NoError(t, r.pollers[name].Poll(context.Background()))
+	}
+	s := r.Sensors()[0]
+	v, err := s.Value(time.Date(2022, 1, 1, 23, 0, 0, 0, time.Local))
+	assert.NoError(t, err)
+	assert.Equal(t, 66.25, v.Temperature.Value, "Group mean weighted with living")
+	v, err = s.Value(time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local))
+	assert.NoError(t, err)
+	assert.Equal(t, 70.0, v.Temperature.Value)
+
+	_, err = ParseConfig([]byte(`{"inputs": {"a": {"type": "hwmon"}}, "sensors": [{"name": "A", "schedule": [{"weights": {"b": 1}}]}]}`))
+	assert.Error(t, err, "Unknown input in schedule")
+}
@@ -0,0 +1,165 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/expr"
+	"github.com/marwatk/tstat-sensor-go/pkg/input"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// Sensor is a running simulated sensor
+type Sensor struct {
+	Config SensorConfig
+	// identity is the stored or generated identity with the config's
+	// overrides, it's what messages are built from
+	identity sensor.Identity
+	// stored is the key store's identity, nil if the sensor isn't in the store.
+	// Only its sequence number is ever changed.
+	stored *sensor.Identity
+	// reserved is the sequence number saved in the key store, see seqReserve
+	reserved int
+	expr     *expr.Expr
+	groups   map[string][]string
+	// inputs are every input the sensor reads, directly or through a group
+	inputs map[string]*input.Poller
+	// backup is the failsafe backup input, if any
+	backup  *input.Poller
+	sender  *sensor.Sender
+	burst   sensor.Burst
+	encoder *sensor.Encoder
+
+	lock  sync.Mutex
+	state sensorState
+}
+
+// Value is a sensor's computed temperature
+type Value struct {
+	Temperature sensor.Temperature
+	// Oldest is the time of the oldest reading that went into it
+	Oldest time.Time
+	// Stale lists inputs whose readings were used even though they're stale
+	Stale []string
+}
+
+// Value computes what the sensor would send at now
+func (s *Sensor) Value(now time.Time) (Value, error) {
+	v, err := s.value(now)
+	if err == nil && s.Config.Calibration != nil {
+		v.Temperature = s.Config.Calibration.Apply(v.Temperature)
+	}
+	return v, err
+}
+
+// value is Value before calibration
+func (s *Sensor) value(now time.Time) (Value, error) {
+	if s.Config.Input != "" {
+		p := s.inputs[s.Config.Input]
+		reading, ok := p.Latest()
+		if !ok {
+			return Value{}, fmt.Errorf("no reading from input [%s] yet", s.Config.Input)
+		}
+		v := Value{Temperature: reading.Temperature, Oldest: reading.Time}
+		if p.Stale() {
+			v.Stale = append(v.Stale, p.Name)
+		}
+		return v, nil
+	}
+
+	v := Value{}
+	read := func(name string) (float64, bool) {
+		p := s.inputs[name]
+		reading, ok := p.Latest()
+		if !ok {
+			return 0, false
+		}
+		if v.Oldest.IsZero() || reading.Time.Before(v.Oldest) {
+			v.Oldest = reading.Time
+		}
+		if p.Stale() {
+			v.Stale = append(v.Stale, name)
+		}
+		return reading.Temperature.In(s.Config.Celsius).Value, true
+	}
+	// readGroup returns the values of group members that have a reading
+	readGroup := func(members []string) []float64 {
+		list := []float64{}
+		for _, m := range members {
+			if f, ok := read(m); ok {
+				list = append(list, f)
+			}
+		}
+		return list
+	}
+
+	if s.expr == nil {
+		sched := s.Config.Schedule
+		i := sched.Active(now)
+		if i < 0 {
+			return Value{}, fmt.Errorf("no schedule entry active")
+		}
+		values := make(map[string]float64)
+		for _, name := range sched[i].Inputs() {
+			if members, ok := s.groups[name]; ok {
+				if list := readGroup(members); len(list) > 0 {
+					values[name] = mean(list)
+				}
+			} else if f, ok := read(name); ok {
+				values[name] = f
+			}
+		}
+		f, _, ok := sched.WeightedMean(now, values)
+		if !ok {
+			return Value{}, fmt.Errorf("no readings for schedule entry [%s]", sched[i].Label(i))
+		}
+		v.Temperature = sensor.Temperature{Value: f, Celsius: s.Config.Celsius}
+		return v, nil
+	}
+
+	env := expr.Env{
+		Values: make(map[string]float64),
+		Lists:  make(map[string][]float64),
+		Now:    now,
+	}
+	for _, name := range s.expr.Vars() {
+		if members, ok := s.groups[name]; ok {
+			// Groups only include members that have a reading
+			env.Lists[name] = readGroup(members)
+		} else if f, ok := read(name); ok {
+			env.Values[name] = f
+		}
+	}
+	f, err := s.expr.Eval(env)
+	if err != nil {
+		return Value{}, fmt.Errorf("error evaluating [%s]: %w", s.expr, err)
+	}
+	v.Temperature = sensor.Temperature{Value: f, Celsius: s.Config.Celsius}
+	return v, nil
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// ready is closed when every input the sensor reads has a reading
+func (s *Sensor) ready(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for _, p := range s.inputs {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.Ready():
+			}
+		}
+		close(done)
+	}()
+	return done
+}
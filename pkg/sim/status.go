@@ -0,0 +1,224 @@
+package sim
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/marwatk/tstat-sensor-go/pkg/metrics"
+	"github.com/marwatk/tstat-sensor-go/pkg/sensor"
+)
+
+// sensorState is what a Sensor tracks for Status
+type sensorState struct {
+	Sent          uint64
+	Skipped       uint64
+	Errors        uint64
+	LastError     error
+	LastSent      time.Time
+	LastValue     sensor.Temperature
+	LastCode      int32
+	FailsafeSince time.Time
+	FailsafeCount uint64
+	// pending is a rate limited change waiting to be sent
+	pending bool
+	// checks counts runOnChange's checks
+	checks uint64
+}
+
+// SensorStatus is a sensor's state as reported by the status endpoint
+type SensorStatus struct {
+	Name string `json:"name"`
+	Sent uint64 `json:"sent"`
+	// Skipped is how many unchanged values weren't sent, see OnChangeConfig
+	Skipped   uint64     `json:"skipped"`
+	Errors    uint64     `json:"errors"`
+	LastError string     `json:"lastError,omitempty"`
+	LastSent  *time.Time `json:"lastSent,omitempty"`
+	// LastValue and LastCode are the last value sent and its wire code
+	LastValue *float64 `json:"lastValue,omitempty"`
+	Celsius   bool     `json:"celsius,omitempty"`
+	LastCode  *int32   `json:"lastCode,omitempty"`
+	// Failsafe is the active failsafe action, blank if it isn't engaged
+	Failsafe      string     `json:"failsafe,omitempty"`
+	FailsafeSince *time.Time `json:"failsafeSince,omitempty"`
+	// FailsafeCount is how many times the failsafe has engaged
+	FailsafeCount uint64 `json:"failsafeCount"`
+}
+
+// InputStatus is an input's state as reported by the status endpoint
+type InputStatus struct {
+	Name        string     `json:"name"`
+	Reads       uint64     `json:"reads"`
+	Errors      uint64     `json:"errors"`
+	Rejected    uint64     `json:"rejected"`
+	LastError   string     `json:"lastError,omitempty"`
+	Stale       bool       `json:"stale"`
+	LastReading *time.Time `json:"lastReading,omitempty"`
+	Value       *float64   `json:"value,omitempty"`
+	Celsius     bool       `json:"celsius,omitempty"`
+}
+
+// Status is the state of every sensor and input
+type Status struct {
+	Sensors []SensorStatus `json:"sensors"`
+	Inputs  []InputStatus  `json:"inputs"`
+}
+
+// Status reports the current state of the runner's sensors and inputs
+func (r *Runner) Status() Status {
+	status := Status{Sensors: []SensorStatus{}, Inputs: []InputStatus{}}
+	for _, s := range r.sensors {
+		status.Sensors = append(status.Sensors, s.Status())
+	}
+	names := make([]string, 0, len(r.pollers))
+	for name := range r.pollers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := r.pollers[name]
+		stats := p.Stats()
+		is := InputStatus{
+			Name:     name,
+			Reads:    stats.Reads,
+			Errors:   stats.Errors,
+			Rejected: stats.Rejected,
+			Stale:    p.Stale(),
+		}
+		if stats.LastError != nil {
+			is.LastError = stats.LastError.Error()
+		}
+		if reading, ok := p.Latest(); ok {
+			is.LastReading = &reading.Time
+			is.Value = &reading.Temperature.Value
+			is.Celsius = reading.Temperature.Celsius
+		}
+		status.Inputs = append(status.Inputs, is)
+	}
+	return status
+}
+
+// Status reports the sensor's current state
+func (s *Sensor) Status() SensorStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := s.state
+	ss := SensorStatus{
+		Name:          s.Config.Name,
+		Sent:          st.Sent,
+		Skipped:       st.Skipped,
+		Errors:        st.Errors,
+		FailsafeCount: st.FailsafeCount,
+	}
+	if st.LastError != nil {
+		ss.LastError = st.LastError.Error()
+	}
+	if !st.LastSent.IsZero() {
+		ss.LastSent = &st.LastSent
+		ss.LastValue = &st.LastValue.Value
+		ss.Celsius = st.LastValue.Celsius
+		ss.LastCode = &st.LastCode
+	}
+	if !st.FailsafeSince.IsZero() {
+		ss.Failsafe = s.Config.Failsafe.Action
+		ss.FailsafeSince = &st.FailsafeSince
+	}
+	return ss
+}
+
+// Handler serves the runner's status as JSON at /status and as Prometheus
+// metrics at /metrics
+func (r *Runner) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r.Status())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		WriteMetrics(w, r.Status())
+	})
+	return mux
+}
+
+// WriteMetrics writes status in the Prometheus text format
+func WriteMetrics(w io.Writer, status Status) {
+	metric := func(name, kind, help string, samples []metrics.Sample) {
+		metrics.Write(w, name, kind, help, samples)
+	}
+	sensors := func(f func(s SensorStatus) (float64, bool)) []metrics.Sample {
+		var samples []metrics.Sample
+		for _, s := range status.Sensors {
+			if v, ok := f(s); ok {
+				samples = append(samples, metrics.Sample{Labels: []metrics.Label{{Name: "sensor", Value: s.Name}}, Value: v})
+			}
+		}
+		return samples
+	}
+	inputs := func(f func(i InputStatus) (float64, bool)) []metrics.Sample {
+		var samples []metrics.Sample
+		for _, i := range status.Inputs {
+			if v, ok := f(i); ok {
+				samples = append(samples, metrics.Sample{Labels: []metrics.Label{{Name: "input", Value: i.Name}}, Value: v})
+			}
+		}
+		return samples
+	}
+
+	metric("tstat_sensor_sent_total", "counter", "Readings sent", sensors(func(s SensorStatus) (float64, bool) {
+		return float64(s.Sent), true
+	}))
+	metric("tstat_sensor_skipped_total", "counter", "Unchanged readings not sent", sensors(func(s SensorStatus) (float64, bool) {
+		return float64(s.Skipped), true
+	}))
+	metric("tstat_sensor_errors_total", "counter", "Readings that couldn't be sent", sensors(func(s SensorStatus) (float64, bool) {
+		return float64(s.Errors), true
+	}))
+	metric("tstat_sensor_last_sent_timestamp_seconds", "gauge", "When the last reading was sent", sensors(func(s SensorStatus) (float64, bool) {
+		if s.LastSent == nil {
+			return 0, false
+		}
+		return float64(s.LastSent.UnixNano()) / 1e9, true
+	}))
+	metric("tstat_sensor_temperature_code", "gauge", "Last temperature code sent", sensors(func(s SensorStatus) (float64, bool) {
+		if s.LastCode == nil {
+			return 0, false
+		}
+		return float64(*s.LastCode), true
+	}))
+	metric("tstat_sensor_temperature_fahrenheit", "gauge", "Last temperature sent", sensors(func(s SensorStatus) (float64, bool) {
+		if s.LastValue == nil {
+			return 0, false
+		}
+		return sensor.Temperature{Value: *s.LastValue, Celsius: s.Celsius}.Fahrenheit(), true
+	}))
+	metric("tstat_sensor_failsafe", "gauge", "Whether the sensor's failsafe is engaged", sensors(func(s SensorStatus) (float64, bool) {
+		return metrics.Bool(s.Failsafe != ""), true
+	}))
+	metric("tstat_sensor_failsafe_engaged_total", "counter", "Times the sensor's failsafe has engaged", sensors(func(s SensorStatus) (float64, bool) {
+		return float64(s.FailsafeCount), true
+	}))
+	metric("tstat_input_reads_total", "counter", "Successful input reads", inputs(func(i InputStatus) (float64, bool) {
+		return float64(i.Reads), true
+	}))
+	metric("tstat_input_errors_total", "counter", "Failed input reads", inputs(func(i InputStatus) (float64, bool) {
+		return float64(i.Errors), true
+	}))
+	metric("tstat_input_rejected_total", "counter", "Input readings rejected by a filter", inputs(func(i InputStatus) (float64, bool) {
+		return float64(i.Rejected), true
+	}))
+	metric("tstat_input_stale", "gauge", "Whether the input's latest reading is stale", inputs(func(i InputStatus) (float64, bool) {
+		return metrics.Bool(i.Stale), true
+	}))
+	metric("tstat_input_temperature_fahrenheit", "gauge", "Latest input reading", inputs(func(i InputStatus) (float64, bool) {
+		if i.Value == nil {
+			return 0, false
+		}
+		return sensor.Temperature{Value: *i.Value, Celsius: i.Celsius}.Fahrenheit(), true
+	}))
+}
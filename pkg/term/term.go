@@ -0,0 +1,13 @@
+// Package term puts a terminal into raw mode for interactive views
+package term
+
+import "errors"
+
+// ErrUnsupported is returned by MakeRaw where raw mode isn't implemented
+var ErrUnsupported = errors.New("raw terminal mode not supported on this platform")
+
+// IsTerminal reports whether fd is a terminal
+func IsTerminal(fd uintptr) bool {
+	_, err := getState(fd)
+	return err == nil
+}
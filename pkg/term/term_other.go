@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package term
+
+func getState(fd uintptr) (struct{}, error) {
+	return struct{}{}, ErrUnsupported
+}
+
+// MakeRaw isn't supported on this platform
+func MakeRaw(fd uintptr) (restore func() error, err error) {
+	return nil, ErrUnsupported
+}
+
+// Size isn't supported on this platform
+func Size(fd uintptr) (int, int, error) {
+	return 0, 0, ErrUnsupported
+}
@@ -0,0 +1,55 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getState(fd uintptr) (*syscall.Termios, error) {
+	t := &syscall.Termios{}
+	return t, ioctl(fd, ioctlGetTermios, unsafe.Pointer(t))
+}
+
+// MakeRaw puts the terminal into raw mode: no echo, no line buffering and no
+// signals from control keys. Call restore to put it back.
+func MakeRaw(fd uintptr) (restore func() error, err error) {
+	old, err := getState(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return ioctl(fd, ioctlSetTermios, unsafe.Pointer(old))
+	}, nil
+}
+
+// Size returns the terminal's width and height in characters
+func Size(fd uintptr) (int, int, error) {
+	var ws struct {
+		Row, Col, X, Y uint16
+	}
+	err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws))
+	return int(ws.Col), int(ws.Row), err
+}